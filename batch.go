@@ -0,0 +1,223 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxPacketBytes is the largest payload that writeLines packs several lines
+// into. It keeps UDP datagrams within a typical Ethernet MTU.
+const maxPacketBytes = 1432
+
+// writeLines sends lines packed into as few payloads of at most
+// maxPacketBytes as possible, separated by the line separator. A line longer
+// than the limit is sent on its own.
+func (c *client) writeLines(lines [][]byte) error {
+	if c.off() {
+		return nil
+	}
+	var b bytes.Buffer
+	for _, line := range lines {
+		if b.Len() > 0 && b.Len()+len(c.lineSeparator)+len(line) > maxPacketBytes {
+			if err := c.write(append([]byte(nil), b.Bytes()...)); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteString(c.lineSeparator)
+		}
+		b.Write(line)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return c.write(b.Bytes())
+}
+
+// batchMetric is a metric waiting to be sent by submitBatch.
+type batchMetric struct {
+	mtype MetricType
+	name  string
+	value string
+	tags  []string
+	rate  float64
+}
+
+// submitBatch sends metrics packed into as few packets as possible. Nothing
+// is sent if any of them cannot be formatted.
+func (c *client) submitBatch(metrics []batchMetric) error {
+	lines := make([][]byte, 0, len(metrics))
+	for _, m := range metrics {
+		data, err := c.format(m.mtype, m.name, m.value, m.tags, m.rate)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			lines = append(lines, data)
+		}
+	}
+	return c.writeLines(lines)
+}
+
+// submitSampled is like submitBatch but sends all of metrics at rate with a
+// single sampling decision, made for the first of them, so that they are
+// kept or dropped together.
+func (c *client) submitSampled(metrics []batchMetric, rate float64) error {
+	if rate < 1 {
+		switch c.sampling {
+		case SampleRandom:
+			if c.random() >= rate {
+				return nil
+			}
+		case SampleDeterministic:
+			if !sampleByHash(c.metricName(metrics[0].name), c.mergeTags(metrics[0].tags), rate) {
+				return nil
+			}
+		}
+		c = c.clone()
+		c.presampled = true
+	}
+	for i := range metrics {
+		metrics[i].rate = rate
+	}
+	return c.submitBatch(metrics)
+}
+
+// HistogramWithCount sends value as a histogram and 1 as the count
+// <name>.count in a single packet, for the common pairing of a latency
+// distribution with a request count. Both are sampled together, so the
+// count always matches the histogram. Nothing is sent if either cannot be
+// formatted.
+func (c *client) HistogramWithCount(name string, value float64, tags []string, rate float64) error {
+	return c.submitSampled([]batchMetric{
+		{mtype: Histogram, name: name, value: c.formatFloat(value), tags: tags},
+		{mtype: Count, name: name + ".count", value: "1", tags: tags},
+	}, rate)
+}
+
+// HistogramEach sends every value as a sample of the histogram name, packed
+// into multi-value lines, "name:v:v:v|h", that are themselves packed into as
+// few packets as possible. The call is sampled as a whole, keeping or
+// dropping all of its values together. Nothing is sent if values is empty
+// or holds NaN or an infinity.
+func (c *client) HistogramEach(name string, values []float64, tags []string, rate float64) error {
+	if len(values) == 0 {
+		return fmt.Errorf("Histogram '%s' requires at least one value", name)
+	}
+	var metrics []batchMetric
+	var line strings.Builder
+	for i, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("Histogram '%s' value %v is not a finite number", name, v)
+		}
+		formatted := c.formatFloat(v)
+		if line.Len() > 0 && line.Len()+1+len(formatted) > maxPacketBytes/2 {
+			metrics = append(metrics, batchMetric{mtype: Histogram, name: name, value: line.String(), tags: tags})
+			line.Reset()
+		}
+		if line.Len() > 0 {
+			line.WriteByte(':')
+		}
+		line.WriteString(formatted)
+		if i == len(values)-1 {
+			metrics = append(metrics, batchMetric{mtype: Histogram, name: name, value: line.String(), tags: tags})
+		}
+	}
+	return c.submitSampled(metrics, rate)
+}
+
+// CountByTag sends one count of name per entry of counts, tagged with
+// baseTags and tagKey:<key>, packed into as few packets as possible in key
+// order, e.g. the number of responses per status code seen in a window.
+// Nothing is sent if any of them cannot be formatted.
+func (c *client) CountByTag(name, tagKey string, counts map[string]int64, baseTags []string, rate float64) error {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	metrics := make([]batchMetric, 0, len(keys))
+	for _, key := range keys {
+		tags := make([]string, 0, len(baseTags)+1)
+		tags = append(tags, baseTags...)
+		tags = append(tags, tagKey+":"+key)
+		metrics = append(metrics, batchMetric{Count, name, strconv.FormatInt(counts[key], 10), tags, rate})
+	}
+	return c.submitBatch(metrics)
+}
+
+// SummaryGauges sends summary statistics from a source that does not expose
+// its raw samples as the gauges <name>.min, <name>.max and <name>.avg and the
+// count <name>.count, in a single packet. Percentiles can't be recovered
+// from a summary; to feed the average into a distribution or histogram
+// weighted by the number of samples, use HistogramCount(name, avg, count).
+func (c *client) SummaryGauges(name string, min, max, avg float64, count int64, tags []string) error {
+	if min > avg || avg > max {
+		return fmt.Errorf("Summary '%s' requires min <= avg <= max, got %v, %v, %v", name, min, avg, max)
+	}
+	return c.submitBatch([]batchMetric{
+		{mtype: Gauge, name: name + ".min", value: c.formatFloat(min), tags: tags, rate: 1},
+		{mtype: Gauge, name: name + ".max", value: c.formatFloat(max), tags: tags, rate: 1},
+		{mtype: Gauge, name: name + ".avg", value: c.formatFloat(avg), tags: tags, rate: 1},
+		{mtype: Count, name: name + ".count", value: strconv.FormatInt(count, 10), tags: tags, rate: 1},
+	})
+}
+
+// GaugesFromMap sends every entry of values as the gauge prefix+key, packed
+// into as few packets as possible in key order. Nothing is sent if any of
+// them cannot be formatted.
+func (c *client) GaugesFromMap(prefix string, values map[string]float64, tags []string, rate float64) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	metrics := make([]batchMetric, 0, len(keys))
+	for _, key := range keys {
+		metrics = append(metrics, batchMetric{Gauge, prefix + key, c.formatFloat(values[key]), tags, rate})
+	}
+	return c.submitBatch(metrics)
+}
+
+// lineWriter forwards newline-delimited DogStatsD lines to a client.
+type lineWriter struct {
+	c       *client
+	mu      sync.Mutex
+	partial []byte
+}
+
+// LineWriter returns a writer that sends each newline-terminated line written
+// to it as an already formatted DogStatsD payload, batching the lines of each
+// Write into as few packets as possible. A line split across several writes
+// is sent once its newline has been written; empty lines are skipped.
+func (c *client) LineWriter() io.Writer {
+	return &lineWriter{c: c}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	var lines [][]byte
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSuffix(w.partial[:i], []byte("\r")); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+		w.partial = w.partial[i+1:]
+	}
+	w.partial = append([]byte(nil), w.partial...)
+	return len(p), w.c.writeLines(lines)
+}
@@ -0,0 +1,249 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+
+	w := client.LineWriter()
+	if _, err := io.WriteString(w, "a.count:1|c\n\na.gauge:2|"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "a.count:1|c"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+
+	if _, err := io.WriteString(w, "g\nb.count:3|c\n"); err != nil {
+		t.Fatal(err)
+	}
+	expected = "a.gauge:2|g\nb.count:3|c"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestWriteLinesSplitsPackets(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	c := newClient(t, addr).(*client)
+	defer c.Close()
+
+	var lines [][]byte
+	for i := 0; i < 200; i++ {
+		lines = append(lines, []byte(fmt.Sprintf("test.count:%d|c", i)))
+	}
+	if err := c.writeLines(lines); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []string
+	for len(received) < len(lines) {
+		bytes := make([]byte, 2*maxPacketBytes)
+		n, _, err := server.ReadFrom(bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > maxPacketBytes {
+			t.Errorf("Expected packets of at most %d bytes, got %d", maxPacketBytes, n)
+		}
+		received = append(received, strings.Split(string(bytes[:n]), "\n")...)
+	}
+	for i, line := range lines {
+		if received[i] != string(line) {
+			t.Errorf("Expected: %s. Actual: %s", line, received[i])
+		}
+	}
+}
+
+func TestGaugesFromMap(t *testing.T) {
+	r := NewRecorder(10)
+	values := map[string]float64{"heap": 3, "goroutines": 12, "conns": 1}
+	if err := r.GaugesFromMap("stats.", values, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := "stats.conns:1.000000|g|#tagA\nstats.goroutines:12.000000|g|#tagA\nstats.heap:3.000000|g|#tagA"
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	if err := r.GaugesFromMap("stats.", nil, nil, 1); err != nil || len(r.Sent()) != 1 {
+		t.Errorf("Expected an empty map to send nothing, got %v", err)
+	}
+}
+
+func TestHistogramWithCount(t *testing.T) {
+	r := NewRecorder(10)
+	if err := r.HistogramWithCount("request.latency", 12.5, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := "request.latency:12.500000|h|#tagA\nrequest.latency.count:1|c|#tagA"
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestHistogramWithCountSampled(t *testing.T) {
+	for _, sampling := range []SamplingStrategy{SampleRandom, SampleDeterministic} {
+		r := &ring{buf: make([]string, 200)}
+		c, err := newConnClient(r, Options{Sampling: sampling})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 200; i++ {
+			c.HistogramWithCount("request.latency", 12.5, []string{fmt.Sprintf("id:%d", i)}, 0.5)
+		}
+		sent := r.contents()
+		if len(sent) == 0 || len(sent) == 200 {
+			t.Errorf("Expected about half of the pairs to be sent, got %d", len(sent))
+		}
+		for _, payload := range sent {
+			if lines := strings.Split(payload, "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "request.latency.count:1|c|@0.500000|") {
+				t.Errorf("Expected the histogram and count to be sent together, got %q", payload)
+			}
+		}
+	}
+}
+
+func TestLineSeparator(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{LineSeparator: "\r\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.GaugesFromMap("stats.", map[string]float64{"a": 1, "b": 2}, nil, 1)
+	expected := "stats.a:1.000000|g\r\nstats.b:2.000000|g"
+	if sent := r.contents(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	r = &ring{buf: make([]string, 10)}
+	c, err = newConnClient(r, Options{Network: "tcp", LineSeparator: ";"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Count("test.count", 1, nil, 1)
+	if sent := r.contents(); len(sent) != 1 || sent[0] != "test.count:1|c;" {
+		t.Errorf("Expected: %q. Actual: %q", "test.count:1|c;", sent)
+	}
+
+	_, err = newConnClient(r, Options{LineSeparator: "|"})
+	if err == nil || err.Error() != `Line separator "|" contains a reserved DogStatsD character` {
+		t.Errorf("Expected error for a reserved separator, got %v", err)
+	}
+}
+
+func TestHistogramEach(t *testing.T) {
+	r := NewRecorder(10)
+	if err := r.HistogramEach("item.duration", []float64{1, 2.5, 3}, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := "item.duration:1.000000:2.500000:3.000000|h|#tagA"
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	values := make([]float64, 500)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	if err := r.HistogramEach("item.duration", values, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	var samples []string
+	for _, payload := range r.Sent()[1:] {
+		if len(payload) > maxPacketBytes {
+			t.Errorf("Expected payloads of at most %d bytes, got %d", maxPacketBytes, len(payload))
+		}
+		for _, line := range strings.Split(payload, "\n") {
+			line = strings.TrimSuffix(strings.TrimPrefix(line, "item.duration:"), "|h")
+			samples = append(samples, strings.Split(line, ":")...)
+		}
+	}
+	if len(samples) != 500 || samples[0] != "0.000000" || samples[499] != "499.000000" {
+		t.Errorf("Expected 500 samples in order, got %d", len(samples))
+	}
+
+	if err := r.HistogramEach("item.duration", nil, nil, 1); err == nil || err.Error() != "Histogram 'item.duration' requires at least one value" {
+		t.Errorf("Expected error for no values, got %v", err)
+	}
+	if err := r.HistogramEach("item.duration", []float64{1, math.NaN()}, nil, 1); err == nil || err.Error() != "Histogram 'item.duration' value NaN is not a finite number" {
+		t.Errorf("Expected error for NaN, got %v", err)
+	}
+}
+
+func TestHistogramEachSampled(t *testing.T) {
+	values := make([]float64, 500)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	r := &ring{buf: make([]string, 20)}
+	c, err := newConnClient(r, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i := 0; i < 100; i++ {
+		r.n, r.start = 0, 0
+		c.HistogramEach("item.duration", values, nil, 0.5)
+		var samples int
+		for _, payload := range r.contents() {
+			for _, line := range strings.Split(payload, "\n") {
+				if !strings.HasSuffix(line, "|h|@0.500000") {
+					t.Fatalf("Expected every line sent at the rate, got %q", line)
+				}
+				samples += strings.Count(line, ":")
+			}
+		}
+		if samples != 0 && samples != len(values) {
+			t.Fatalf("Expected all or none of the values sent, got %d", samples)
+		}
+		if samples != 0 {
+			kept++
+		}
+	}
+	if kept == 0 || kept == 100 {
+		t.Errorf("Expected about half of the calls to be sent, got %d", kept)
+	}
+}
+
+func TestSummaryGauges(t *testing.T) {
+	r := NewRecorder(10)
+	if err := r.SummaryGauges("db.query", 1, 9, 4, 12, []string{"tagA"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "db.query.min:1.000000|g|#tagA\ndb.query.max:9.000000|g|#tagA\ndb.query.avg:4.000000|g|#tagA\ndb.query.count:12|c|#tagA"
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	err := r.SummaryGauges("db.query", 5, 9, 4, 12, nil)
+	if err == nil || err.Error() != "Summary 'db.query' requires min <= avg <= max, got 5, 4, 9" {
+		t.Errorf("Expected error for an inconsistent summary, got %v", err)
+	}
+}
+
+func TestCountByTag(t *testing.T) {
+	r := NewRecorder(10)
+	counts := map[string]int64{"500": 2, "200": 40, "404": 3}
+	if err := r.CountByTag("http.responses", "status", counts, []string{"env:prod"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := "http.responses:40|c|#env:prod,status:200\nhttp.responses:3|c|#env:prod,status:404\nhttp.responses:2|c|#env:prod,status:500"
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
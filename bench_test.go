@@ -0,0 +1,180 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"testing"
+	"time"
+)
+
+// discardTransport accepts and drops every payload.
+type discardTransport struct{}
+
+func (discardTransport) Write(p []byte) (int, error) { return len(p), nil }
+func (discardTransport) Close() error                { return nil }
+
+// newBenchClient returns a client discarding its payloads.
+func newBenchClient(tb testing.TB, opts Options) *client {
+	c, err := newConnClient(discardTransport{}, opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// assertAllocs fails t if f allocates more than max times on average. It
+// does nothing under the race detector, which adds allocations.
+func assertAllocs(t *testing.T, max float64, f func()) {
+	t.Helper()
+	if raceEnabled {
+		return
+	}
+	if allocs := testing.AllocsPerRun(100, f); allocs > max {
+		t.Errorf("Expected at most %v allocations, got %v", max, allocs)
+	}
+}
+
+func TestHotPathAllocs(t *testing.T) {
+	c := newBenchClient(t, Options{})
+	c.SetNamespace("flubber.")
+	c.SetTags([]string{"env:prod"})
+	tags := []string{"tagA", "tagB:c"}
+
+	assertAllocs(t, 9, func() { c.Gauge("test.gauge", 1, nil, 1) })
+	assertAllocs(t, 10, func() { c.Gauge("test.gauge", 1, tags, 1) })
+	assertAllocs(t, 9, func() { c.Count("test.count", 1, tags, 1) })
+	assertAllocs(t, 10, func() { c.Timing("test.timer", time.Millisecond, tags, 1) })
+	assertAllocs(t, 11, func() { c.Info("title", "text", tags) })
+
+	null := NewNull()
+	mark := time.Now()
+	var items interface{} = tags
+	for name, f := range map[string]func() error{
+		"Gauge":                func() error { return null.Gauge("test.gauge", 1, tags, 1) },
+		"GaugeBool":            func() error { return null.GaugeBool("test.gauge", true, tags, 1) },
+		"GaugeProbabilistic":   func() error { return null.GaugeProbabilistic("test.gauge", 0.5, 1, tags) },
+		"GaugeNow":             func() error { return null.GaugeNow("test.gauge", 1, tags, 1) },
+		"GaugeBytes":           func() error { return null.GaugeBytes("test.gauge", "body", tags, 1) },
+		"GaugeOnChange":        func() error { return null.GaugeOnChange("test.gauge", 1, tags) },
+		"Count":                func() error { return null.Count("test.count", 1, tags, 1) },
+		"CountLen":             func() error { return null.CountLen("test.count", items, tags, 1) },
+		"CountNow":             func() error { return null.CountNow("test.count", 1, tags, 1) },
+		"CountFloat":           func() error { return null.CountFloat("test.count", 0.1, tags, 1) },
+		"CountSampled":         func() error { return null.CountSampled("test.count", 1, tags, FullSampleRate) },
+		"Histogram":            func() error { return null.Histogram("test.histogram", 1, tags, 1) },
+		"Timer":                func() error { return null.Timer("test.timer", 1, tags, 1) },
+		"Timing":               func() error { return null.Timing("test.timer", time.Millisecond, tags, 1) },
+		"TimingMicros":         func() error { return null.TimingMicros("test.timer", time.Millisecond, tags, 1) },
+		"MeasureSince":         func() error { return null.MeasureSince("test.timer", mark, tags) },
+		"DistributionDuration": func() error { return null.DistributionDuration("test.distribution", time.Millisecond, tags, 1) },
+		"Set":                  func() error { return null.Set("test.set", "user", tags, 1) },
+		"Submit":               func() error { return null.Submit(Gauge, "test.gauge", 1, tags, 1) },
+		"SubmitSampled":        func() error { return null.SubmitSampled(Gauge, "test.gauge", 1, tags, FullSampleRate) },
+		"SendTo":               func() error { return null.SendTo("localhost:1201", Gauge, "test.gauge", 1, tags, 1) },
+	} {
+		t.Run("Null"+name, func(t *testing.T) {
+			assertAllocs(t, 0, func() { f() })
+		})
+	}
+}
+
+func BenchmarkGauge(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Gauge("test.gauge", 1, nil, 1)
+	}
+}
+
+func BenchmarkGaugeTagged(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	c.SetNamespace("flubber.")
+	c.SetTags([]string{"env:prod", "region:us"})
+	tags := []string{"tagA", "tagB:c"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Gauge("test.gauge", 1, tags, 1)
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Count("test.count", 1, nil, 1)
+	}
+}
+
+func BenchmarkCountTagged(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	tags := []string{"tagA", "tagB:c"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Count("test.count", 1, tags, 1)
+	}
+}
+
+func BenchmarkCountSampled(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Count("test.count", 1, nil, 0.1)
+	}
+}
+
+func BenchmarkEvent(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	tags := []string{"tagA", "tagB:c"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Info("title", "text", tags)
+	}
+}
+
+func BenchmarkGaugeAsync(b *testing.B) {
+	c := newBenchClient(b, Options{QueueSize: 1024})
+	defer c.Close()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Gauge("test.gauge", 1, nil, 1)
+	}
+}
+
+func BenchmarkGaugeAsyncParallel(b *testing.B) {
+	c := newBenchClient(b, Options{QueueSize: 1024})
+	defer c.Close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Gauge("test.gauge", 1, nil, 1)
+		}
+	})
+}
+
+func BenchmarkGaugeSyncParallel(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Gauge("test.gauge", 1, nil, 1)
+		}
+	})
+}
+
+func BenchmarkBatch(b *testing.B) {
+	c := newBenchClient(b, Options{})
+	values := map[string]float64{"a": 1, "b": 2, "c": 3, "d": 4}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.GaugesFromMap("stats.", values, nil, 1)
+	}
+}
+
+func BenchmarkNull(b *testing.B) {
+	c := NewNull()
+	tags := []string{"tagA"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Gauge("test.gauge", 1, tags, 1)
+	}
+}
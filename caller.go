@@ -0,0 +1,35 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// packageDir is the directory of the package source, used to skip the
+// package's own frames when looking for the caller.
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// callerTag returns the tag caller:<file>:<line> for the first function on
+// the stack outside this package, with file being the base name of the
+// source file.
+func callerTag() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		own := filepath.Dir(frame.File) == packageDir && !strings.HasSuffix(frame.File, "_test.go")
+		if !own && frame.File != "<autogenerated>" {
+			return "caller:" + filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return "caller:unknown"
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DatadogGoEncoder encodes metrics as the official github.com/DataDog/datadog-go
+// client does. It differs from DogStatsDEncoder only in sending the sample
+// rate in its shortest exact form, "|@0.5" rather than "|@0.500000". It is
+// the encoder of Options.DatadogGoCompat, which also matches the formatting
+// of values and events.
+type DatadogGoEncoder struct{}
+
+func (DatadogGoEncoder) Encode(m Metric) []byte {
+	var b bytes.Buffer
+	// format has already checked the type.
+	suffix, _ := m.Type.suffix()
+	fmt.Fprintf(&b, "%s:%s|%s", m.Name, m.Value, suffix)
+	if m.Rate < 1 {
+		fmt.Fprintf(&b, "|@%s", strconv.FormatFloat(m.Rate, 'f', -1, 64))
+	}
+	if len(m.Tags) > 0 {
+		fmt.Fprintf(&b, "|#%s", strings.Join(m.Tags, ","))
+	}
+	if !m.Timestamp.IsZero() {
+		fmt.Fprintf(&b, "|T%d", m.Timestamp.Unix())
+	}
+	if m.Cardinality != "" {
+		fmt.Fprintf(&b, "|card:%s", m.Cardinality)
+	}
+	return b.Bytes()
+}
+
+// eventNewlineEscaper escapes the newlines of event texts as datadog-go
+// does, so that they don't end the payload.
+var eventNewlineEscaper = strings.NewReplacer("\n", `\n`)
+
+// encodeDatadogGoEvent formats an event as datadog-go does: with the newlines
+// of the text escaped and counted escaped in its length, the title sent as
+// is, the optional fields in alphabetical order of their prefixes, and the
+// alert type only when set:
+//
+//	_e{<title length>,<text length>}:<title>|<text>|d:<date>|h:<host>|k:<key>|p:<priority>|s:<source>|t:<alert type>|#<tags>
+func (c *client) encodeDatadogGoEvent(title string, text string, eo *EventOpts, tags []string) []byte {
+	text = eventNewlineEscaper.Replace(text)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "_e{%d,%d}:%s|%s", len(title), len(text), title, text)
+
+	if !eo.DateHappened.IsZero() {
+		fmt.Fprintf(&b, "|d:%d", eo.DateHappened.Unix())
+	}
+	if eo.Host != "" {
+		fmt.Fprintf(&b, "|h:%s", eo.Host)
+	}
+	aggregationKey := eo.AggregationKey
+	if aggregationKey == "" {
+		aggregationKey = c.aggregationKey
+	}
+	if aggregationKey != "" {
+		fmt.Fprintf(&b, "|k:%s", aggregationKey)
+	}
+	if eo.Priority != "" {
+		fmt.Fprintf(&b, "|p:%s", eo.Priority)
+	}
+	if eo.SourceTypeName != "" {
+		fmt.Fprintf(&b, "|s:%s", eo.SourceTypeName)
+	}
+	if eo.AlertType != "" {
+		fmt.Fprintf(&b, "|t:%s", eo.AlertType)
+	}
+	if len(tags) > 0 {
+		fmt.Fprintf(&b, "|#%s", strings.Join(tags, ","))
+	}
+	return b.Bytes()
+}
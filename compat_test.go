@@ -0,0 +1,62 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The expected payloads follow the formatting code of
+// github.com/DataDog/datadog-go, its format functions and appendEvent, for
+// the same calls. They were derived from that source, not captured from a
+// running datadog-go client.
+func TestDatadogGoCompat(t *testing.T) {
+	r := &ring{buf: make([]string, 20)}
+	c, err := newConnClient(r, Options{DatadogGoCompat: true, FloatPrecision: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetNamespace("flubber.")
+	c.SetTags([]string{"env:prod"})
+
+	c.Gauge("test.gauge", 1.5, []string{"tagA"}, 1)
+	c.Gauge("test.gauge", 1e21, nil, 1)
+	c.Count("test.count", 3, nil, 1)
+	c.Histogram("test.histogram", 0.1, nil, 1)
+	c.Timing("test.timing", 1500*time.Microsecond, nil, 1)
+	c.Set("test.set", "a", nil, 1)
+	c.Event("title\nhere", "line1\nline2", &EventOpts{
+		DateHappened:   time.Unix(1, 0),
+		Priority:       Low,
+		Host:           "host",
+		AggregationKey: "key",
+		SourceTypeName: "source",
+		AlertType:      Error,
+		Tags:           []string{"tagA"},
+	})
+	c.Event("title", "text", &EventOpts{})
+
+	expected := []string{
+		"flubber.test.gauge:1.5|g|#env:prod,tagA",
+		"flubber.test.gauge:1000000000000000000000|g|#env:prod",
+		"flubber.test.count:3|c|#env:prod",
+		"flubber.test.histogram:0.1|h|#env:prod",
+		"flubber.test.timing:1.5|ms|#env:prod",
+		"flubber.test.set:a|s|#env:prod",
+		"_e{10,12}:title\nhere|line1\\nline2|d:1|h:host|k:key|p:low|s:source|t:error|#env:prod,tagA",
+		"_e{5,4}:title|text|#env:prod",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestDatadogGoEncoder(t *testing.T) {
+	m := Metric{Name: "test.count", Type: Count, Value: "3", Rate: 0.25, Tags: []string{"tagA"}, Timestamp: time.Unix(1, 0)}
+	expected := "test.count:3|c|@0.25|#tagA|T1"
+	if payload := string(DatadogGoEncoder{}.Encode(m)); payload != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, payload)
+	}
+}
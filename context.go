@@ -0,0 +1,54 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"context"
+	"sync"
+)
+
+type tagsKey struct{}
+
+// ContextWithTags returns a copy of ctx carrying tags in addition to any
+// tags already stored in ctx.
+func ContextWithTags(ctx context.Context, tags ...string) context.Context {
+	existing := TagsFromContext(ctx)
+	merged := make([]string, 0, len(existing)+len(tags))
+	merged = append(merged, existing...)
+	merged = append(merged, tags...)
+	return context.WithValue(ctx, tagsKey{}, merged)
+}
+
+// TagsFromContext returns the tags stored in ctx by ContextWithTags.
+func TagsFromContext(ctx context.Context) []string {
+	tags, _ := ctx.Value(tagsKey{}).([]string)
+	return tags
+}
+
+// WithContext returns a client sharing c's connection and settings that adds
+// the tags stored in ctx to every metric and event. Tags are sent in the
+// order global tags, context tags, then the tags given to each call.
+func (c *client) WithContext(ctx context.Context) Client {
+	return c.WithTags(TagsFromContext(ctx)...)
+}
+
+type clientKey struct{}
+
+// NewContext returns a copy of ctx carrying c, for FromContext to return.
+func NewContext(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// nullClient is the client returned by FromContext when ctx holds none.
+var nullClient = sync.OnceValue(NewNull)
+
+// FromContext returns the Client stored in ctx by NewContext. If there is
+// none it returns a client that discards everything sent to it, so callers
+// never need to check for nil. That client is shared by every such call,
+// so it should not be configured or closed.
+func FromContext(ctx context.Context) Client {
+	if c, ok := ctx.Value(clientKey{}).(Client); ok && c != nil {
+		return c
+	}
+	return nullClient()
+}
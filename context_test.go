@@ -0,0 +1,74 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestTagsFromContext(t *testing.T) {
+	ctx := context.Background()
+	if tags := TagsFromContext(ctx); tags != nil {
+		t.Errorf("Expected no tags, got %v", tags)
+	}
+	ctx = ContextWithTags(ctx, "request_id:1")
+	ctx = ContextWithTags(ctx, "trace_id:2")
+	expected := []string{"request_id:1", "trace_id:2"}
+	if tags := TagsFromContext(ctx); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected: %v. Actual: %v", expected, tags)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+	client.SetTags([]string{"env:prod"})
+
+	ctx := ContextWithTags(context.Background(), "request_id:1")
+	if err := client.WithContext(ctx).Count("test.count", 1, []string{"tagA"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:1|c|#env:prod,request_id:1,tagA"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+
+	if tags := client.GetTags(); !reflect.DeepEqual(tags, []string{"env:prod"}) {
+		t.Errorf("Expected parent tags to be unchanged, got %v", tags)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	discard := FromContext(context.Background())
+	if discard == nil {
+		t.Fatal("Expected a no-op client, got nil")
+	}
+	if err := discard.Count("test.count", 1, nil, 1.0); err != nil {
+		t.Errorf("Expected the no-op client to accept metrics, got %v", err)
+	}
+	if FromContext(context.Background()) != discard {
+		t.Error("Expected the same no-op client every time")
+	}
+	if allocs := testing.AllocsPerRun(10, func() { FromContext(context.Background()) }); allocs != 0 {
+		t.Errorf("Expected FromContext not to allocate, got %v", allocs)
+	}
+	discard.RegisterGauge("test.gauge", nil, func() float64 { return 1 })
+	if names := discard.RegisteredGauges(); len(names) != 0 {
+		t.Errorf("Expected the no-op client not to register gauges, got %q", names)
+	}
+
+	r := NewRecorder(10)
+	ctx := NewContext(context.Background(), r)
+	if err := FromContext(ctx).Count("test.count", 1, nil, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"test.count:1|c"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
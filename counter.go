@@ -0,0 +1,173 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter accumulates a count locally so that it can be sent as a single
+// metric by FlushCounter. It is safe for concurrent use.
+type Counter struct {
+	value int64
+
+	mu      sync.Mutex
+	windows map[int64]int64
+	// Amounts added with AddSampled, the rate they share, and their sum
+	// scaled by their rates, used instead once rates differ
+	sampled     int64
+	sampledRate float64
+	mixed       bool
+	scaled      float64
+}
+
+// Add adds delta to the counter. The counter saturates at math.MaxInt64 or
+// math.MinInt64 rather than wrapping around.
+func (c *Counter) Add(delta int64) {
+	for {
+		old := atomic.LoadInt64(&c.value)
+		if atomic.CompareAndSwapInt64(&c.value, old, saturatingAdd(old, delta)) {
+			return
+		}
+	}
+}
+
+// AddAt adds delta to the one-second window containing ts rather than to the
+// current count. When the counter is flushed, each window is sent as its own
+// count timestamped with the start of that window, so replayed data lands on
+// the second it happened instead of the time of the flush. Windows may be
+// added in any order; they are sent oldest first. Like WithTimestamp, windows
+// outside the range accepted by Datadog are discarded with an error.
+func (c *Counter) AddAt(delta int64, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.windows == nil {
+		c.windows = make(map[int64]int64)
+	}
+	sec := ts.Unix()
+	c.windows[sec] = saturatingAdd(c.windows[sec], delta)
+}
+
+// AddSampled adds delta, an amount counted for only a fraction rate of the
+// events, as when calls were sampled before reaching the counter. If every
+// amount added since the last flush has the same rate, the counter is sent
+// with that rate so that the agent scales it; otherwise, including when Add
+// was also called, each amount is divided by its own rate and the sum is
+// sent with a rate of 1, rounded to an integer. A rate of 1 or more, or of
+// 0 or less, is the same as Add.
+func (c *Counter) AddSampled(delta int64, rate float64) {
+	if rate >= 1 || rate <= 0 {
+		c.Add(delta)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sampledRate == 0 {
+		c.sampledRate = rate
+	} else if c.sampledRate != rate {
+		c.mixed = true
+	}
+	c.sampled = saturatingAdd(c.sampled, delta)
+	c.scaled += float64(delta) / rate
+}
+
+// Value returns the amount accumulated since the last flush, including the
+// amounts added with AddAt, and those added with AddSampled divided by their
+// rate.
+func (c *Counter) Value() int64 {
+	value := atomic.LoadInt64(&c.value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range c.windows {
+		value = saturatingAdd(value, v)
+	}
+	if c.sampledRate != 0 {
+		value = saturatingAdd(value, int64(math.Round(c.scaled)))
+	}
+	return value
+}
+
+// saturatingAdd returns a+b clamped to the range of an int64.
+func saturatingAdd(a, b int64) int64 {
+	sum := a + b
+	if b > 0 && sum < a {
+		return math.MaxInt64
+	} else if b < 0 && sum > a {
+		return math.MinInt64
+	}
+	return sum
+}
+
+// counterSet holds the Counters of a client keyed by name and tags.
+type counterSet struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// Counter returns the Counter for name and tags, creating it if needed.
+func (c *client) Counter(name string, tags []string) *Counter {
+	c.counters.mu.Lock()
+	defer c.counters.mu.Unlock()
+	key := c.seriesKey(name, tags)
+	counter, ok := c.counters.counters[key]
+	if !ok {
+		counter = &Counter{}
+		c.counters.counters[key] = counter
+	}
+	return counter
+}
+
+// FlushCounter resets the Counter for name and tags to zero and sends the
+// value it held as a count, with the rate of the amounts added with
+// AddSampled if they all share one. Amounts added with AddAt are sent as
+// separate timestamped counts, one per window. It returns the total value
+// sent, before any scaling by the agent, or zero without sending anything if
+// no such Counter exists.
+func (c *client) FlushCounter(name string, tags []string) (int64, error) {
+	c.counters.mu.Lock()
+	counter, ok := c.counters.counters[c.seriesKey(name, tags)]
+	c.counters.mu.Unlock()
+	if !ok {
+		return 0, nil
+	}
+	value := atomic.SwapInt64(&counter.value, 0)
+	counter.mu.Lock()
+	windows := counter.windows
+	counter.windows = nil
+	sampled, rate, mixed, scaled := counter.sampled, counter.sampledRate, counter.mixed, counter.scaled
+	counter.sampled, counter.sampledRate, counter.mixed, counter.scaled = 0, 0, false, 0
+	counter.mu.Unlock()
+
+	var errs []error
+	switch {
+	case rate == 0:
+		if value != 0 || len(windows) == 0 {
+			errs = append(errs, c.Count(name, value, tags, 1))
+		}
+	case value == 0 && !mixed:
+		// The amounts were sampled by the caller: send them as they are.
+		presampled := c.clone()
+		presampled.presampled = true
+		errs = append(errs, presampled.Count(name, sampled, tags, rate))
+		value = sampled
+	default:
+		value = saturatingAdd(value, int64(math.Round(scaled)))
+		errs = append(errs, c.Count(name, value, tags, 1))
+	}
+	secs := make([]int64, 0, len(windows))
+	for sec := range windows {
+		secs = append(secs, sec)
+	}
+	sort.Slice(secs, func(i, j int) bool { return secs[i] < secs[j] })
+	total := value
+	for _, sec := range secs {
+		total = saturatingAdd(total, windows[sec])
+		errs = append(errs, c.WithTimestamp(time.Unix(sec, 0)).Count(name, windows[sec], tags, 1))
+	}
+	return total, errors.Join(errs...)
+}
@@ -0,0 +1,186 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlushCounter(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+
+	counter := client.Counter("test.count", []string{"tagA"})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter.Add(2)
+		}()
+	}
+	wg.Wait()
+	if same := client.Counter("test.count", []string{"tagA"}); same != counter {
+		t.Error("Expected the same Counter for the same name and tags")
+	}
+
+	value, err := client.FlushCounter("test.count", []string{"tagA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 20 {
+		t.Errorf("Expected flushed value 20, got %d", value)
+	}
+	expected := "test.count:20|c|#tagA"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+	if counter.Value() != 0 {
+		t.Errorf("Expected counter to be reset, got %d", counter.Value())
+	}
+
+	if value, err := client.FlushCounter("test.other", nil); value != 0 || err != nil {
+		t.Errorf("Expected unknown counter to flush nothing, got %d, %v", value, err)
+	}
+}
+
+func TestCounterAddSampled(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+
+	// A single rate is sent as is, without being sampled again.
+	for i := 0; i < 5; i++ {
+		r.Counter("test.same", nil).AddSampled(3, 0.5)
+		r.Counter("test.same", nil).AddSampled(1, 0.5)
+		if value, err := r.FlushCounter("test.same", nil); value != 4 || err != nil {
+			t.Fatalf("Expected 4 sent, got %d, %v", value, err)
+		}
+	}
+
+	// Mixed rates are scaled by the client.
+	mixed := r.Counter("test.mixed", []string{"tagA"})
+	mixed.AddSampled(1, 0.5)
+	mixed.AddSampled(1, 0.25)
+	if value := mixed.Value(); value != 6 {
+		t.Errorf("Expected the scaled value 6, got %d", value)
+	}
+	r.FlushCounter("test.mixed", []string{"tagA"})
+
+	// So are sampled amounts mixed with unsampled ones.
+	unsampled := r.Counter("test.unsampled", nil)
+	unsampled.Add(2)
+	unsampled.AddSampled(1, 0.1)
+	unsampled.AddSampled(5, 1)
+	if value, _ := r.FlushCounter("test.unsampled", nil); value != 17 {
+		t.Errorf("Expected 17 sent, got %d", value)
+	}
+
+	// The rate is forgotten on flush.
+	unsampled.AddSampled(1, 0.25)
+	r.FlushCounter("test.unsampled", nil)
+
+	expected := []string{
+		"test.same:4|c|@0.500000",
+		"test.same:4|c|@0.500000",
+		"test.same:4|c|@0.500000",
+		"test.same:4|c|@0.500000",
+		"test.same:4|c|@0.500000",
+		"test.mixed:6|c|#tagA",
+		"test.unsampled:17|c",
+		"test.unsampled:1|c|@0.250000",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestCounterDerivedClients(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	a, b := r.WithTags("env:a"), r.WithTags("env:b")
+	if a.Counter("test.count", nil) == b.Counter("test.count", nil) {
+		t.Fatal("Expected clients with different global tags to have different Counters")
+	}
+	a.Counter("test.count", nil).Add(1)
+	b.Counter("test.count", nil).Add(2)
+	r.WithNamespace("x").Counter("test.count", nil).Add(3)
+	a.FlushCounter("test.count", nil)
+	b.FlushCounter("test.count", nil)
+	r.WithNamespace("x").FlushCounter("test.count", nil)
+	if value, _ := r.FlushCounter("test.count", nil); value != 0 {
+		t.Errorf("Expected no Counter for the base client, got %d", value)
+	}
+
+	expected := []string{"test.count:1|c|#env:a", "test.count:2|c|#env:b", "x.test.count:3|c"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestCounterSaturates(t *testing.T) {
+	var c Counter
+	c.Add(math.MaxInt64 - 1)
+	c.Add(1)
+	if c.Value() != math.MaxInt64 {
+		t.Errorf("Expected %d, got %d", int64(math.MaxInt64), c.Value())
+	}
+	c.Add(10)
+	if c.Value() != math.MaxInt64 {
+		t.Errorf("Expected counter to saturate at %d, got %d", int64(math.MaxInt64), c.Value())
+	}
+
+	c = Counter{}
+	c.Add(math.MinInt64 + 1)
+	c.Add(-10)
+	if c.Value() != math.MinInt64 {
+		t.Errorf("Expected counter to saturate at %d, got %d", int64(math.MinInt64), c.Value())
+	}
+}
+
+func TestCounterAddAtReplay(t *testing.T) {
+	r := NewRecorder(10)
+	base := time.Now().Add(-time.Minute).Truncate(time.Second)
+	counter := r.Counter("replay.count", []string{"tagA"})
+	counter.AddAt(1, base.Add(2*time.Second))
+	counter.AddAt(2, base)
+	counter.AddAt(3, base.Add(2*time.Second+500*time.Millisecond))
+	counter.AddAt(4, base.Add(time.Second))
+	counter.Add(5)
+	if counter.Value() != 15 {
+		t.Errorf("Expected value 15, got %d", counter.Value())
+	}
+
+	value, err := r.FlushCounter("replay.count", []string{"tagA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 15 {
+		t.Errorf("Expected flushed value 15, got %d", value)
+	}
+	sec := base.Unix()
+	expected := []string{
+		"replay.count:5|c|#tagA",
+		fmt.Sprintf("replay.count:2|c|#tagA|T%d", sec),
+		fmt.Sprintf("replay.count:4|c|#tagA|T%d", sec+1),
+		fmt.Sprintf("replay.count:4|c|#tagA|T%d", sec+2),
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+	if counter.Value() != 0 {
+		t.Errorf("Expected counter to be reset, got %d", counter.Value())
+	}
+
+	counter.AddAt(1, base.Add(-2*time.Hour))
+	if _, err := r.FlushCounter("replay.count", []string{"tagA"}); err == nil {
+		t.Error("Expected an error for a window outside the accepted range")
+	}
+}
@@ -26,95 +26,1075 @@ package dogstatsd
 
 import (
 	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
 	"math/rand"
 	"net"
+	"os"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 )
 
+// Version is the version of this client library.
+const Version = "0.1.0"
+
 type Client interface {
 	Close() error
-	Info(string, string, []string) error
-	Success(string, string, []string) error
-	Warning(string, string, []string) error
-	Error(string, string, []string) error
+	Flush() error
+	Info(string, string, []string, ...EventOption) error
+	Success(string, string, []string, ...EventOption) error
+	Warning(string, string, []string, ...EventOption) error
+	Error(string, string, []string, ...EventOption) error
 	Event(string, string, *EventOpts) error
+	ErrorEvent(error, []string) error
+	DeployEvent(string, string, []string, ...EventOption) error
+	EventFromLog(string, map[string]interface{}) error
 	Gauge(string, float64, []string, float64) error
+	GaugeBool(string, bool, []string, float64) error
+	GaugeProbabilistic(string, float64, float64, []string) error
+	GaugeNow(string, float64, []string, float64) error
+	GaugeBytes(string, interface{}, []string, float64) error
+	GaugesFromMap(string, map[string]float64, []string, float64) error
 	Count(string, int64, []string, float64) error
+	CountLen(string, interface{}, []string, float64) error
+	CountNow(string, int64, []string, float64) error
+	CountFloat(string, float64, []string, float64) error
+	CountByTag(string, string, map[string]int64, []string, float64) error
 	Histogram(string, float64, []string, float64) error
+	HistogramCount(string, float64, int, []string, float64) error
+	HistogramWithCount(string, float64, []string, float64) error
+	HistogramEach(string, []float64, []string, float64) error
+	SummaryGauges(string, float64, float64, float64, int64, []string) error
+	Timer(string, float64, []string, float64) error
+	Timing(string, time.Duration, []string, float64) error
+	TimingMicros(string, time.Duration, []string, float64) error
+	Mark(string) time.Time
+	MeasureSince(string, time.Time, []string) error
+	DistributionDuration(string, time.Duration, []string, float64) error
 	Set(string, string, []string, float64) error
+	SetValues(string, []string, []string, float64) error
+	Submit(MetricType, string, float64, []string, float64) error
+	SendTo(string, MetricType, string, float64, []string, float64) error
+	SubmitSampled(MetricType, string, float64, []string, SampleRate) error
+	CountSampled(string, int64, []string, SampleRate) error
 	GetNamespace() string
 	SetNamespace(string)
+	RegisterEventSource(string)
 	GetTags() []string
 	SetTags([]string)
+	Snapshot() func()
+	WithTags(...string) Client
+	WithNamespace(string) Client
+	WithUnit(string) Client
+	WithCardinality(Cardinality) Client
+	WithContext(context.Context) Client
+	WithPrecision(int) Client
+	WithTimestamp(time.Time) Client
+	Dropped() uint64
+	QueueDepth() int
+	QueueCapacity() int
+	Counter(string, []string) *Counter
+	FlushCounter(string, []string) (int64, error)
+	LineWriter() io.Writer
+	RegisterGauge(string, []string, func() float64)
+	RegisterUtilization(string, []string, func() (int, int))
+	RegisterGaugeWithTTL(string, []string, func() float64, time.Duration)
+	RegisterTTLGauge(string, []string, time.Duration) *TTLGauge
+	UnregisterGauge(string, []string, bool) error
+	RegisteredGauges() []string
+	CountRate(string, int64, []string)
+	RecordRate(string, []string)
+	StartRuntimeMetrics(time.Duration, []string) error
+	RecordHTTP(string, string, int, time.Duration, []string) error
+	GaugeOnChange(string, float64, []string) error
 }
 
 type client struct {
-	conn net.Conn
+	conn Transport
+	// Guards namespace, tags and eventSource
+	mu *sync.RWMutex
 	// Namespace to prepend to all statsd calls
 	namespace string
 	// Global tags to be added to every statsd call
 	tags []string
+	// Source type of events set by RegisterEventSource, empty to derive it from the namespace
+	eventSource string
+	// Host reported by the event helpers, empty unless Options.EventHost is set
+	eventHost string
+	// Bounds the rate of events, nil when unlimited
+	eventLimiter *eventLimiter
+	// Suppresses repeated events, nil when disabled
+	eventDeduper *eventDeduper
+	// Origin tag cardinality requested from the agent, omitted when empty
+	cardinality Cardinality
+	// Digits after the decimal point for float values, -1 for the fewest needed
+	precision int
+	// Writes payloads from a background goroutine, nil when writing synchronously
+	queue *queue
+	// Locally accumulated counts, shared with derived clients
+	counters *counterSet
+	// Time sent as the |T field of metrics, omitted when zero
+	timestamp time.Time
+	// Set when metrics with a rate below 1 were already sampled by the
+	// caller, as the counts of FlushCounter, and must be sent as they are
+	presampled bool
+	// Prepended to the key of every tag except the internal dd.* tags
+	tagPrefix string
+	// Aggregation key of events that do not set their own
+	aggregationKey string
+	// Gauges polled on the flush interval, shared with derived clients
+	gauges *gaugeSet
+	// How often registered gauges are reported
+	flushInterval time.Duration
+	// Length of the sliding window of RecordRate
+	rateWindow time.Duration
+	// Background goroutine reporting on the flush interval, shared with derived clients
+	periodic *periodic
+	// Background goroutine reporting runtime metrics, shared with derived clients
+	runtimeMetrics *periodic
+	// Tag identifying the client version, empty unless Options.VersionTag is set
+	versionTag string
+	// Inserted between the namespace and metric names
+	namespaceSeparator string
+	// Guards Close, shared with derived clients
+	closeOnce *sync.Once
+	// Set by Close, shared with derived clients
+	closed *atomic.Bool
+	// Returns the current time; tests replace it to control time
+	now func() time.Time
+	// Last values sent by GaugeOnChange, shared with derived clients
+	lastGauges *lastGauges
+	// Whether conn is a stream, which needs payloads terminated by newlines
+	stream bool
+	// How many times, and after how long at first, event writes are retried
+	eventRetries      int
+	eventRetryBackoff time.Duration
+	// Formats metrics into payloads
+	encoder Encoder
+	// Most global and per-call tags a metric or event may have, -1 for no limit
+	maxTags int
+	// How metrics sent with a rate below 1 are sampled
+	sampling SamplingStrategy
+	// Whether per-call tags replace global tags with the same key
+	overrideTags bool
+	// Longest event text sent, 0 for no limit other than maxEventBytes
+	eventTextMaxBytes int
+	// Connections opened by SendTo, shared with derived clients
+	sendTo *sendToConns
+	// Whether events are encoded as by datadog-go
+	datadogGoCompat bool
+	// Placeholders expanded in metric names, shared with derived clients
+	templates *nameTemplates
+	// Whether the event source is derived from the whole namespace
+	eventSourceFullNamespace bool
+	// Whether sampled counts are scaled by the client instead of annotated
+	localScaling bool
+	// Whether metrics are tagged with the code that sent them
+	callerTag bool
+	// Separates packed lines and ends payloads on streams
+	lineSeparator string
+	// Set for the clients of NewNull, which send nothing
+	null bool
+	// Added to every metric, empty for none
+	hostTag string
+	// Seeded source of random sampling decisions, shared with derived
+	// clients, nil to use the global source
+	rng *lockedRand
+	// Log fields sent by EventFromLog as tags rather than text
+	logTagFields map[string]bool
+}
+
+// lockedRand is a rand.Rand safe for concurrent use.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// random returns a random number in [0, 1) for a sampling decision.
+func (c *client) random() float64 {
+	if c.rng == nil {
+		return rand.Float64()
+	}
+	c.rng.mu.Lock()
+	defer c.rng.mu.Unlock()
+	return c.rng.r.Float64()
+}
+
+// SamplingStrategy decides which metrics sent with a rate below 1 are kept.
+type SamplingStrategy int
+
+const (
+	// SampleRandom keeps each metric independently with probability rate.
+	SampleRandom SamplingStrategy = iota
+	// SampleDeterministic keeps a metric if a hash of its name and tags falls
+	// below rate, so a given series is either always or never sent.
+	SampleDeterministic
+)
+
+// lastGauges holds the last value sent of each gauge keyed by name and tags.
+type lastGauges struct {
+	mu     sync.Mutex
+	values map[string]lastGauge
 }
 
+// lastGauge is a value sent by GaugeOnChange and when it was sent.
+type lastGauge struct {
+	value float64
+	sent  time.Time
+}
+
+// Cardinality is the level of origin detection tags the agent adds to a metric.
+type Cardinality string
+
+const (
+	CardinalityNone         Cardinality = "none"
+	CardinalityLow          Cardinality = "low"
+	CardinalityOrchestrator Cardinality = "orchestrator"
+	CardinalityHigh         Cardinality = "high"
+)
+
+// Options holds optional client settings. The zero value gives the same
+// behavior as New.
+type Options struct {
+	// EventHost makes Info, Success, Warning and Error set EventOpts.Host
+	// to the result of os.Hostname().
+	EventHost bool
+	// EventsPerSecond limits how many events are sent per second. Events over
+	// the limit are discarded and Event returns ErrEventRateLimited. Zero means
+	// no limit.
+	EventsPerSecond float64
+	// EventBurst is the number of events that may be sent at once before
+	// EventsPerSecond applies. It defaults to 1.
+	EventBurst int
+	// Cardinality is sent as the |card: field of every metric. It is omitted
+	// when empty, which older agents require.
+	Cardinality Cardinality
+	// FloatPrecision is the number of digits after the decimal point sent for
+	// float values. Zero uses DefaultFloatPrecision and a negative value sends
+	// the fewest digits that represent the value exactly.
+	FloatPrecision int
+	// QueueSize enables asynchronous sending: metrics and events are queued
+	// and written to the connection by a background goroutine, and write
+	// errors are no longer returned to the caller. Zero sends synchronously.
+	// The single writer goroutine sends payloads in the order they were
+	// queued, so metrics sent one after another from one goroutine arrive in
+	// that order, short of any dropped because of DropOnFull.
+	QueueSize int
+	// DropOnFull makes a full queue discard new payloads, counting them in
+	// Dropped, instead of blocking the caller until there is room.
+	DropOnFull bool
+	// TagPrefix is prepended to every global and per-call tag, so that with
+	// a TagPrefix of "myapp." the tag "env:prod" is sent as "myapp.env:prod".
+	// Internal tags starting with "dd." are sent unchanged.
+	TagPrefix string
+	// EventAggregationKey is the aggregation key of events whose EventOpts
+	// leave AggregationKey empty, so that related events are grouped together.
+	EventAggregationKey string
+	// FlushInterval is how often registered gauges are reported, and how long
+	// GaugeOnChange skips an unchanged value. It defaults to
+	// DefaultFlushInterval, the agent's own flush interval.
+	FlushInterval time.Duration
+	// VersionTag adds the tag dd.internal.client_version:<Version> to every
+	// metric, to correlate metric behavior with client upgrades.
+	VersionTag bool
+	// NamespaceSeparator is inserted between the namespace and metric names,
+	// unless the namespace already ends with it: both "flubber" and
+	// "flubber." send "flubber.request.duration". It defaults to ".".
+	NamespaceSeparator string
+	// Network is the network passed to net.Dial: "udp" (the default),
+	// "unixgram", or one of the stream networks "tcp" and "unix". Payloads
+	// sent over a stream are terminated by a newline.
+	Network string
+	// EventRetries is how many times an event write that timed out is
+	// retried on a stream network. Datagram writes are not retried: they
+	// either fail at once or are lost without an error.
+	EventRetries int
+	// EventRetryBackoff is how long to wait before the first event retry.
+	// The wait doubles for every following retry.
+	EventRetryBackoff time.Duration
+	// Encoder formats metrics into payloads. It defaults to DogStatsDEncoder.
+	// Events are always sent in the DogStatsD format.
+	Encoder Encoder
+	// MaxTags is the most global and per-call tags a metric or event may
+	// have together. Metrics and events with more are discarded with an
+	// error, to guard against tags accidentally appended in a loop. It
+	// defaults to DefaultMaxTags; a negative value disables the limit.
+	MaxTags int
+	// Sampling decides which metrics sent with a rate below 1 are kept. It
+	// defaults to SampleRandom.
+	Sampling SamplingStrategy
+	// OverrideTags makes a per-call tag replace the global tags with the same
+	// key, the part before the first colon, so that with the global tag
+	// "env:staging" a call tagged "env:test" is sent only "env:test". By
+	// default both are sent.
+	OverrideTags bool
+	// EventTextMaxBytes caps the text of every event at this many bytes.
+	// Longer texts are cut on a UTF-8 boundary and ended with "...", within
+	// the cap, or cut without it if the cap is under 3 bytes, before the
+	// payload is assembled; titles, tags and the mentions of
+	// EventOpts.Mentions are kept in full. The whole payload must still fit
+	// in 8KB. Zero means no cap.
+	EventTextMaxBytes int
+	// EventSourceFullNamespace derives the event source type from the whole
+	// namespace, with dots replaced by underscores since source types can't
+	// contain them, instead of from its first component: the namespace
+	// "billing.jobs." gives the source "billing_jobs" rather than "billing".
+	EventSourceFullNamespace bool
+	// MetadataTags adds the global tags host:<os.Hostname()> and
+	// pid:<os.Getpid()>, and version:<AppVersion> when AppVersion is set,
+	// when the client is created. Like other global tags they are replaced
+	// by SetTags. They are off by default as they add cardinality.
+	MetadataTags bool
+	// AppVersion is the version sent in the version: tag of MetadataTags.
+	AppVersion string
+	// LocalScaling makes counts sent with a rate below 1 be divided by the
+	// rate by the client and sent without the |@ annotation, for downstream
+	// systems that don't understand it. The scaled value is rounded to an
+	// integer, so small counts at low rates are less accurate than when the
+	// agent scales them, and the agent can no longer tell that the count was
+	// sampled. Other metric types are always sent with the annotation, which
+	// the agent needs to weigh their samples.
+	LocalScaling bool
+	// CallerTag adds the tag caller:<file>:<line> to every metric, naming
+	// the source file and line outside this package that sent it, to track
+	// down unexpected metrics while debugging. It walks the stack on every
+	// metric, costing a few microseconds and allocations each, and creates a
+	// series per call site, so it is meant for development only.
+	CallerTag bool
+	// WriteTimeout bounds how long each write to the connection may block,
+	// by setting a write deadline before it on connections that support one,
+	// such as those of every network. A write that times out returns an
+	// error satisfying net.Error with Timeout() true. Zero means no deadline.
+	WriteTimeout time.Duration
+	// LineSeparator separates the lines packed into one payload and ends
+	// every payload sent over a stream network, for receivers expecting
+	// another delimiter. It defaults to "\n", the DogStatsD separator, and
+	// may not contain any of the characters of the DogStatsD format
+	// ":|#@,". It does not change how LineWriter splits its input.
+	LineSeparator string
+	// QueueLatency reports the mean time payloads spent in the queue of an
+	// asynchronous client before being written, in milliseconds, as the
+	// gauge datadog.dogstatsd.client.queue_latency on every flush interval.
+	// The gauge is sent without the namespace. A growing latency shows the
+	// writer goroutine can't keep up. It has no effect without QueueSize.
+	QueueLatency bool
+	// LocalAddr is the local address, in the form "host:port" or ":port",
+	// the connection is bound to, for firewalls that only allow a fixed
+	// source port. It is only supported on the udp and tcp networks. By
+	// default the system picks an ephemeral port.
+	LocalAddr string
+	// DefaultHostTag adds the tag host:<DefaultHostTag> to every metric, to
+	// attribute metrics forwarded by a proxy to the host they came from, as
+	// the metric format has no host field. It replaces the host: tag of
+	// MetadataTags. Events are not tagged: they carry their host in the
+	// separate EventOpts.Host field set by EventHost or WithHost.
+	DefaultHostTag string
+	// EventDedupWindow suppresses an event with the same title, text and
+	// aggregation key as one sent less than this long before, so that an
+	// error firing in a loop doesn't flood the event stream. Suppressed
+	// events return ErrEventDuplicate. Zero sends every event.
+	EventDedupWindow time.Duration
+	// EventDedupMetric, if set, is the name of a count incremented, with
+	// the tags of the event, for every event suppressed by EventDedupWindow.
+	EventDedupMetric string
+	// SamplingSeed, if not zero, seeds the random numbers deciding which
+	// metrics sent with a rate below 1 are kept, so that a load test keeps
+	// the same metrics on every run. It makes sampling predictable, which
+	// someone able to trigger metrics could exploit, so don't set it in
+	// production.
+	SamplingSeed int64
+	// LogTagFields are the fields of the log entries given to EventFromLog
+	// that are sent as tags instead of as part of the text.
+	LogTagFields []string
+	// NamePlaceholders are substituted for the placeholders of metric names
+	// and namespaces, written as the key in braces: with the placeholder
+	// "region" set to "eu", the name "service.{region}.requests" is sent as
+	// "service.eu.requests". Unknown placeholders are sent unchanged. Names
+	// are expanded once and cached, so sending a templated name costs a map
+	// lookup. Values may not contain any of the characters ":|#@,".
+	NamePlaceholders map[string]string
+	// RateWindow is the length of the sliding window over which RecordRate
+	// computes rates, rounded up to whole seconds. It defaults to
+	// DefaultRateWindow.
+	RateWindow time.Duration
+	// DatadogGoCompat formats payloads exactly as the official
+	// github.com/DataDog/datadog-go client does, so that migrating from it
+	// leaves dashboards unchanged. It fixes these differences:
+	//
+	//   - Float values are sent in their shortest exact form, "1.5" rather
+	//     than "1.500000"; FloatPrecision is ignored.
+	//   - Sample rates are sent in their shortest exact form, "|@0.5"
+	//     rather than "|@0.500000", by DatadogGoEncoder, which is used
+	//     unless Encoder is set.
+	//   - Newlines in event texts are escaped as \n, and the text length
+	//     in the event header counts the escaped text. Without
+	//     compatibility a newline is sent as is and ends the event early.
+	//     Titles are sent as is by both clients.
+	//   - Event fields follow the text in the order d, h, k, p, s, t, and
+	//     the alert type is omitted when empty rather than sent as "t:".
+	//
+	// Tags are already sent in the same order, global tags first, and the
+	// lengths in event headers are byte counts in both clients, so UTF-8
+	// titles and texts need no fix. Options adding fields datadog-go does
+	// not send, such as VersionTag or TagPrefix, still apply.
+	DatadogGoCompat bool
+}
+
+// reservedSeparatorChars are the characters of the DogStatsD format, which
+// would make lines ambiguous if used in Options.LineSeparator.
+const reservedSeparatorChars = ":|#@,"
+
+// DefaultMaxTags is the tag limit used unless Options.MaxTags is set.
+const DefaultMaxTags = 100
+
+// DefaultFloatPrecision is the float precision used unless Options.FloatPrecision is set.
+const DefaultFloatPrecision = 6
+
 // New returns a pointer to a new client and an error.
 // addr must have the format "hostname:port"
 func New(addr string) (Client, error) {
-	conn, err := net.Dial("udp", addr)
+	return NewWithOptions(addr, Options{})
+}
+
+// NewAsync is like New but sends asynchronously through a queue of
+// queueSize payloads, blocking the caller while the queue is full. It is
+// shorthand for NewWithOptions with Options.QueueSize; use Options.DropOnFull
+// to discard payloads instead of blocking.
+func NewAsync(addr string, queueSize int) (Client, error) {
+	return NewWithOptions(addr, Options{QueueSize: queueSize})
+}
+
+// NewWithSeed is like New but seeds sampling with seed, see
+// Options.SamplingSeed.
+func NewWithSeed(addr string, seed int64) (Client, error) {
+	return NewWithOptions(addr, Options{SamplingSeed: seed})
+}
+
+// NewWithOptions is like New but configures the client with opts.
+func NewWithOptions(addr string, opts Options) (Client, error) {
+	network := opts.Network
+	if network == "" {
+		network = "udp"
+	}
+	var dialer net.Dialer
+	if opts.LocalAddr != "" {
+		var err error
+		if dialer.LocalAddr, err = resolveLocalAddr(network, opts.LocalAddr); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := dialer.Dial(network, addr)
 	if err != nil {
+		if opts.LocalAddr != "" {
+			return nil, fmt.Errorf("Dial from local address '%s' failed: %w", opts.LocalAddr, err)
+		}
+		return nil, err
+	}
+	client, err := newConnClient(conn, opts)
+	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	client := &client{conn: conn}
 	return client, nil
 }
 
-// Close closes the connection to the DogStatsD agent
+// Transport delivers the payloads formatted by a client, e.g. by posting them
+// to an HTTP collector or handing them to an in-memory queue. Each Write is
+// one payload: a metric, an event or several lines packed together. Close is
+// called once, by the Close of the client.
+type Transport interface {
+	Write([]byte) (int, error)
+	Close() error
+}
+
+// NewWithTransport is like NewWithOptions but delivers payloads through t
+// instead of a connection dialed by the client. Options.Network and
+// Options.LocalAddr are not used to connect: a stream Network such as "tcp"
+// only makes every payload end with a newline, as the stream networks need.
+// Options.WriteTimeout applies if t has a SetWriteDeadline method.
+func NewWithTransport(t Transport, opts Options) (Client, error) {
+	client, err := newConnClient(t, opts)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// resolveLocalAddr resolves addr as a local address for network.
+func resolveLocalAddr(network, addr string) (net.Addr, error) {
+	var local net.Addr
+	var err error
+	switch network {
+	case "udp", "udp4", "udp6":
+		local, err = net.ResolveUDPAddr(network, addr)
+	case "tcp", "tcp4", "tcp6":
+		local, err = net.ResolveTCPAddr(network, addr)
+	default:
+		return nil, fmt.Errorf("Local address is not supported on network '%s'", network)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid local address '%s': %w", addr, err)
+	}
+	return local, nil
+}
+
+// newConnClient returns a client configured with opts that writes to conn.
+func newConnClient(conn Transport, opts Options) (*client, error) {
+	client := &client{
+		conn:               conn,
+		mu:                 &sync.RWMutex{},
+		cardinality:        opts.Cardinality,
+		precision:          DefaultFloatPrecision,
+		tagPrefix:          opts.TagPrefix,
+		aggregationKey:     opts.EventAggregationKey,
+		counters:           &counterSet{counters: make(map[string]*Counter)},
+		gauges:             newGaugeSet(),
+		flushInterval:      DefaultFlushInterval,
+		rateWindow:         DefaultRateWindow,
+		periodic:           &periodic{},
+		runtimeMetrics:     &periodic{},
+		namespaceSeparator: ".",
+		closeOnce:          &sync.Once{},
+		closed:             &atomic.Bool{},
+		now:                time.Now,
+		lastGauges:         &lastGauges{values: make(map[string]lastGauge)},
+		sendTo:             &sendToConns{conns: make(map[string]net.Conn)},
+		encoder:            opts.Encoder,
+		sampling:           opts.Sampling,
+		overrideTags:       opts.OverrideTags,
+		eventTextMaxBytes:  opts.EventTextMaxBytes,
+	}
+	client.eventSourceFullNamespace = opts.EventSourceFullNamespace
+	client.localScaling = opts.LocalScaling
+	client.callerTag = opts.CallerTag
+	client.lineSeparator = "\n"
+	if opts.LineSeparator != "" {
+		if strings.ContainsAny(opts.LineSeparator, reservedSeparatorChars) {
+			return nil, fmt.Errorf("Line separator %q contains a reserved DogStatsD character", opts.LineSeparator)
+		}
+		client.lineSeparator = opts.LineSeparator
+	}
+	var err error
+	if client.templates, err = newNameTemplates(opts.NamePlaceholders); err != nil {
+		return nil, err
+	}
+	if client.encoder == nil {
+		client.encoder = DogStatsDEncoder{}
+	}
+	switch {
+	case opts.MaxTags > 0:
+		client.maxTags = opts.MaxTags
+	case opts.MaxTags < 0:
+		client.maxTags = -1
+	default:
+		client.maxTags = DefaultMaxTags
+	}
+	if opts.NamespaceSeparator != "" {
+		client.namespaceSeparator = opts.NamespaceSeparator
+	}
+	if opts.FlushInterval > 0 {
+		client.flushInterval = opts.FlushInterval
+	}
+	if opts.RateWindow > 0 {
+		client.rateWindow = opts.RateWindow
+	}
+	switch opts.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		client.stream = true
+		client.eventRetries = opts.EventRetries
+		client.eventRetryBackoff = opts.EventRetryBackoff
+	}
+	if opts.VersionTag {
+		client.versionTag = "dd.internal.client_version:" + Version
+	}
+	if opts.FloatPrecision != 0 {
+		client.precision = opts.FloatPrecision
+	}
+	if opts.DatadogGoCompat {
+		client.datadogGoCompat = true
+		client.precision = -1
+		if opts.Encoder == nil {
+			client.encoder = DatadogGoEncoder{}
+		}
+	}
+	if opts.EventHost {
+		var err error
+		if client.eventHost, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	client.logTagFields = make(map[string]bool, len(opts.LogTagFields))
+	for _, field := range opts.LogTagFields {
+		client.logTagFields[field] = true
+	}
+	if opts.SamplingSeed != 0 {
+		client.rng = &lockedRand{r: rand.New(rand.NewSource(opts.SamplingSeed))}
+	}
+	if opts.DefaultHostTag != "" {
+		client.hostTag = "host:" + opts.DefaultHostTag
+	}
+	if opts.MetadataTags {
+		if opts.DefaultHostTag == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, err
+			}
+			client.tags = []string{"host:" + hostname}
+		}
+		client.tags = append(client.tags, "pid:"+strconv.Itoa(os.Getpid()))
+		if opts.AppVersion != "" {
+			client.tags = append(client.tags, "version:"+opts.AppVersion)
+		}
+	}
+	if opts.EventsPerSecond > 0 {
+		client.eventLimiter = newEventLimiter(opts.EventsPerSecond, opts.EventBurst)
+	}
+	if opts.EventDedupWindow > 0 {
+		client.eventDeduper = &eventDeduper{
+			window: opts.EventDedupWindow,
+			metric: opts.EventDedupMetric,
+			sent:   make(map[string]time.Time),
+		}
+	}
+	if d, ok := conn.(deadliner); ok && opts.WriteTimeout > 0 {
+		client.conn = &deadlineConn{Transport: client.conn, d: d, timeout: opts.WriteTimeout}
+	}
+	if client.stream {
+		client.conn = &streamConn{client.conn}
+	}
+	if opts.QueueSize > 0 {
+		client.queue = newQueue(client.conn, opts.QueueSize, opts.DropOnFull, opts.QueueLatency)
+		if opts.QueueLatency {
+			self := client.clone()
+			self.namespace = ""
+			self.RegisterGauge("datadog.dogstatsd.client.queue_latency", nil, func() float64 {
+				return durationMs(client.queue.latency())
+			})
+		}
+	}
+	return client, nil
+}
+
+// Close closes the connection to the DogStatsD agent, first unregistering
+// all gauges, stopping runtime metrics and waiting for any queued payloads
+// to be written. It returns every error encountered joined together; the
+// last error writing queued payloads is included. Only the first call to
+// Close on a client or any client derived from it has an effect. Once closed,
+// every method sending a metric or event returns ErrClientClosed.
 func (c *client) Close() error {
-	return c.conn.Close()
+	var err error
+	c.closeOnce.Do(func() {
+		c.periodic.close()
+		c.runtimeMetrics.close()
+		c.closed.Store(true)
+		c.gauges.mu.Lock()
+		c.gauges.gauges = make(map[string]*registeredGauge)
+		c.gauges.rates = make(map[string]*Counter)
+		c.gauges.windows = make(map[string]*rateWindow)
+		c.gauges.mu.Unlock()
+		var errs []error
+		if c.queue != nil {
+			errs = append(errs, c.queue.close())
+		}
+		errs = append(errs, c.conn.Close(), c.sendTo.close())
+		err = errors.Join(errs...)
+	})
+	return err
+}
+
+// Dropped returns how many payloads were discarded because the queue was full.
+func (c *client) Dropped() uint64 {
+	if c.queue == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.queue.dropped)
+}
+
+// QueueDepth returns how many payloads are waiting in the queue of an
+// asynchronous client, or 0 for a synchronous one. Together with
+// QueueCapacity it shows backpressure before payloads start being dropped,
+// e.g. reported with
+//
+//	c.RegisterUtilization("queue.utilization", nil, func() (int, int) {
+//		return c.QueueDepth(), c.QueueCapacity()
+//	})
+func (c *client) QueueDepth() int {
+	if c.queue == nil {
+		return 0
+	}
+	return len(c.queue.ch)
+}
+
+// QueueCapacity returns the size of the queue of an asynchronous client, or
+// 0 for a synchronous one.
+func (c *client) QueueCapacity() int {
+	if c.queue == nil {
+		return 0
+	}
+	return cap(c.queue.ch)
 }
 
 func (c *client) GetNamespace() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.namespace
 }
 
 func (c *client) SetNamespace(namespace string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.namespace = namespace
 }
 
+// RegisterEventSource sets the source type name of all events sent by the
+// client, grouping them under name in the Datadog event stream instead of
+// under the first component of the namespace. A source set on an individual
+// event with WithSourceTypeName still takes precedence.
+func (c *client) RegisterEventSource(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eventSource = name
+}
+
+// defaultEventSource returns the registered event source or, if none is
+// registered, the source derived from the namespace.
+func (c *client) defaultEventSource() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.eventSource != "" {
+		return c.eventSource
+	}
+	source := c.namespace
+	if c.eventSourceFullNamespace {
+		source = strings.TrimSuffix(source, c.namespaceSeparator)
+		return strings.ReplaceAll(strings.TrimSuffix(source, "."), ".", "_")
+	}
+	if period := strings.IndexByte(source, '.'); period > -1 {
+		source = source[:period]
+	}
+	return source
+}
+
 func (c *client) GetTags() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.tags
 }
 
 func (c *client) SetTags(tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.tags = tags
 }
 
+// Snapshot returns a function that restores the namespace and global tags c
+// has now, undoing any SetNamespace and SetTags calls made in between.
+func (c *client) Snapshot() func() {
+	c.mu.RLock()
+	namespace, tags := c.namespace, append([]string(nil), c.tags...)
+	c.mu.RUnlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.namespace, c.tags = namespace, tags
+	}
+}
+
+// WithTags returns a client sharing c's connection and settings that adds
+// tags to the global tags of c. Derived clients can be derived again, and
+// GetTags returns all the tags accumulated along the way, from the root
+// client to the leaf. Metrics carry these followed by their own tags; when
+// several share a key, e.g. env:prod and env:test, all of them are sent and
+// Datadog treats the metric as having each value.
+func (c *client) WithTags(tags ...string) Client {
+	cc := c.clone()
+	cc.tags = append(append([]string(nil), cc.tags...), tags...)
+	return cc
+}
+
+// WithNamespace returns a client sharing c's connection and settings whose
+// namespace is the namespace of c followed by namespace, joined with the
+// namespace separator: deriving "db" from a client with namespace "flubber."
+// sends "flubber.db.query" for the metric "query".
+func (c *client) WithNamespace(namespace string) Client {
+	cc := c.clone()
+	if cc.namespace != "" && !strings.HasSuffix(cc.namespace, cc.namespaceSeparator) {
+		cc.namespace += cc.namespaceSeparator
+	}
+	cc.namespace += namespace
+	return cc
+}
+
+// WithUnit returns a client sharing c's connection and settings that tags
+// every metric and event with unit:<unit>, e.g. c.WithUnit("bytes").
+func (c *client) WithUnit(unit string) Client {
+	return c.WithTags("unit:" + unit)
+}
+
+// WithCardinality returns a client sharing c's connection and settings that
+// sends card as the origin tag cardinality instead of the client default.
+func (c *client) WithCardinality(card Cardinality) Client {
+	cc := c.clone()
+	cc.cardinality = card
+	return cc
+}
+
+// WithPrecision returns a client sharing c's connection and settings that
+// sends float values with prec digits after the decimal point, or the fewest
+// digits needed when prec is negative.
+func (c *client) WithPrecision(prec int) Client {
+	cc := c.clone()
+	cc.precision = prec
+	return cc
+}
+
+// Bounds of the metric timestamps accepted by Datadog, relative to now.
+const (
+	maxTimestampAge    = time.Hour
+	maxTimestampFuture = 10 * time.Minute
+)
+
+// WithTimestamp returns a client sharing c's connection and settings that
+// sends ts as the time of every metric, of any type, instead of letting the
+// agent use the time it received them. Datadog only accepts timestamps from
+// one hour in the past to ten minutes in the future, so metrics timestamped
+// outside that window are not sent and return an error.
+func (c *client) WithTimestamp(ts time.Time) Client {
+	cc := c.clone()
+	cc.timestamp = ts
+	return cc
+}
+
+// metricKey identifies a metric by name and tags.
+func metricKey(name string, tags []string) string {
+	return name + "|" + strings.Join(tags, ",")
+}
+
+// seriesKey identifies the series c sends for name and tags, including the
+// namespace and global tags of c, so that clients derived with another
+// namespace or tags don't share counters and gauges kept for it.
+func (c *client) seriesKey(name string, tags []string) string {
+	return metricKey(c.metricName(name), c.mergeTags(tags))
+}
+
+// clone returns a shallow copy of c sharing its connection.
+func (c *client) clone() *client {
+	c.mu.RLock()
+	cc := *c
+	c.mu.RUnlock()
+	cc.mu = &sync.RWMutex{}
+	return &cc
+}
+
+// mergeTags returns the global tags followed by tags, with the tag prefix applied.
+func (c *client) mergeTags(tags []string) []string {
+	c.mu.RLock()
+	merged := make([]string, 0, len(c.tags)+len(tags))
+	if c.overrideTags && len(tags) > 0 {
+		keys := make(map[string]bool, len(tags))
+		for _, t := range tags {
+			keys[tagKey(t)] = true
+		}
+		for _, t := range c.tags {
+			if !keys[tagKey(t)] {
+				merged = append(merged, t)
+			}
+		}
+	} else {
+		merged = append(merged, c.tags...)
+	}
+	c.mu.RUnlock()
+	merged = append(merged, tags...)
+	if c.tagPrefix != "" {
+		for i, t := range merged {
+			if !strings.HasPrefix(t, "dd.") {
+				merged[i] = c.tagPrefix + t
+			}
+		}
+	}
+	return merged
+}
+
+// metricName returns name with the namespace prepended and placeholders
+// expanded.
+func (c *client) metricName(name string) string {
+	namespace := c.GetNamespace()
+	if namespace == "" || strings.HasSuffix(namespace, c.namespaceSeparator) {
+		return c.templates.expand(namespace + name)
+	}
+	return c.templates.expand(namespace + c.namespaceSeparator + name)
+}
+
+// tagKey returns the key of tag, the part before the first colon.
+func tagKey(tag string) string {
+	if i := strings.IndexByte(tag, ':'); i > -1 {
+		return tag[:i]
+	}
+	return tag
+}
+
 // send handles sampling and sends the message over UDP. It also adds global namespace prefixes and tags.
-func (c *client) send(name string, value string, tags []string, rate float64) error {
+func (c *client) send(mtype MetricType, name string, value string, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	data, err := c.format(mtype, name, value, tags, rate)
+	if data == nil {
+		return err
+	}
+	return c.write(data)
+}
+
+// format handles sampling and encodes a metric, adding global namespace
+// prefixes and tags. It returns nil if the metric is sampled out.
+func (c *client) format(mtype MetricType, name string, value string, tags []string, rate float64) ([]byte, error) {
+	if _, err := mtype.suffix(); err != nil {
+		return nil, err
+	}
+	m := Metric{Type: mtype, Value: value, Rate: 1, Cardinality: c.cardinality}
 	if rate < 1 {
-		if rand.Float64() < rate {
-			value = fmt.Sprintf("%s|@%f", value, rate)
-		} else {
-			return nil
+		if c.sampling == SampleRandom && !c.presampled && c.random() >= rate {
+			return nil, nil
 		}
+		m.Rate = rate
 	}
 
-	if c.namespace != "" {
-		name = fmt.Sprintf("%s%s", c.namespace, name)
+	m.Name = c.metricName(name)
+
+	m.Tags = c.mergeTags(tags)
+	if c.maxTags >= 0 && len(m.Tags) > c.maxTags {
+		return nil, fmt.Errorf("Metric '%s' has %d tags, more than the limit of %d, metric discarded", m.Name, len(m.Tags), c.maxTags)
+	}
+	if c.versionTag != "" {
+		m.Tags = append(m.Tags, c.versionTag)
+	}
+	if c.hostTag != "" {
+		m.Tags = append(m.Tags, c.hostTag)
+	}
+	if c.callerTag {
+		m.Tags = append(m.Tags, callerTag())
+	}
+	if m.Rate < 1 && c.sampling == SampleDeterministic && !c.presampled && !sampleByHash(m.Name, m.Tags, m.Rate) {
+		return nil, nil
 	}
 
-	tags = append(c.tags, tags...)
-	if len(tags) > 0 {
-		value = fmt.Sprintf("%s|#%s", value, strings.Join(tags, ","))
+	if !c.timestamp.IsZero() {
+		now := c.now()
+		if c.timestamp.Before(now.Add(-maxTimestampAge)) || c.timestamp.After(now.Add(maxTimestampFuture)) {
+			return nil, fmt.Errorf("Metric '%s' timestamp %d is outside the accepted window, metric discarded", m.Name, c.timestamp.Unix())
+		}
+		m.Timestamp = c.timestamp
 	}
 
-	data := fmt.Sprintf("%s:%s", name, value)
-	_, err := c.conn.Write([]byte(data))
+	if c.localScaling && m.Type == Count && m.Rate < 1 {
+		if v, err := strconv.ParseFloat(m.Value, 64); err == nil {
+			m.Value = strconv.FormatInt(int64(math.Round(v/m.Rate)), 10)
+			m.Rate = 1
+		}
+	}
+
+	return c.encoder.Encode(m), nil
+}
+
+// sampleByHash reports whether the series with name and tags is kept at
+// rate, using the FNV-1a hash of both scaled to [0, 1).
+func sampleByHash(name string, tags []string, rate float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	for _, t := range tags {
+		h.Write([]byte{','})
+		h.Write([]byte(t))
+	}
+	return float64(h.Sum64())/(1<<64) < rate
+}
+
+// ShardTag returns the tag shard:<n> assigning key to one of shards shards,
+// n being the 32-bit FNV-1a hash of key modulo shards, so that a
+// high-cardinality metric can be spread evenly across shards, e.g.
+// c.Count("jobs", 1, []string{dogstatsd.ShardTag(userID, 16)}, 1). The hash
+// is part of the API and will not change, so a key keeps its shard across
+// versions. A shards value below 1 is treated as 1.
+func ShardTag(key string, shards int) string {
+	if shards < 1 {
+		shards = 1
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return "shard:" + strconv.FormatUint(uint64(h.Sum32())%uint64(shards), 10)
+}
+
+// Flush waits until every payload sent before the call has been written to
+// the connection and returns the last error writing any of them. Only
+// asynchronous clients (Options.QueueSize) have anything to wait for;
+// synchronous clients have already written each payload and reported its
+// error, so Flush returns nil at once.
+//
+// What a completed write confirms depends on the network. On "tcp" and
+// "unix" the kernel has accepted the bytes into the socket's send buffer,
+// but the agent may not have read them yet, and a broken connection is often
+// only reported by a later write. On "unixgram" the datagram has been queued
+// on the agent's socket. On "udp" nothing is confirmed: the datagram may be
+// lost anywhere on the way without an error.
+func (c *client) Flush() error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if c.queue == nil {
+		return nil
+	}
+	return c.queue.flush()
+}
+
+// write sends data to the agent, or queues it when sending asynchronously.
+func (c *client) write(data []byte) error {
+	if c.queue == nil {
+		return c.writeNow(data)
+	}
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if c.stream {
+		data = append(data, c.lineSeparator...)
+	}
+	return c.queue.enqueue(data)
+}
+
+// writeNow writes data to the connection, bypassing the queue.
+func (c *client) writeNow(data []byte) error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if c.stream {
+		data = append(data, c.lineSeparator...)
+	}
+	_, err := c.conn.Write(data)
 	return err
 }
 
+// sendNow is like send but writes the metric to the connection at once,
+// bypassing the queue of an asynchronous client.
+func (c *client) sendNow(mtype MetricType, name string, value string, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	data, err := c.format(mtype, name, value, tags, rate)
+	if data == nil {
+		return err
+	}
+	return c.writeNow(data)
+}
+
 // AlertType represents the supported alert_types of Datadog events.
 type AlertType string
 
@@ -131,6 +1111,91 @@ const (
 	maxEventBytes              = 8192
 )
 
+// ErrClientClosed is returned when sending a metric or event with a client
+// that has been closed.
+var ErrClientClosed = errors.New("Client is closed")
+
+// ErrEventRateLimited is returned by Event when Options.EventsPerSecond is exceeded.
+var ErrEventRateLimited = errors.New("Event rate limit exceeded, event discarded")
+
+// eventLimiter is a token bucket bounding the rate of events.
+type eventLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newEventLimiter(rate float64, burst int) *eventLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &eventLimiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow reports whether an event may be sent at now, taking a token if so.
+func (l *eventLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// ErrEventDuplicate is returned by Event for an event suppressed by
+// Options.EventDedupWindow.
+var ErrEventDuplicate = errors.New("Event repeated within the deduplication window, event discarded")
+
+// eventDeduper remembers when events were last sent to suppress repeats.
+type eventDeduper struct {
+	mu     sync.Mutex
+	window time.Duration
+	metric string
+	sent   map[string]time.Time
+	// When entries older than the window were last removed from sent
+	swept time.Time
+}
+
+// allow reports whether the event identified by key may be sent at now,
+// recording it as sent if so.
+func (d *eventDeduper) allow(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.swept) >= d.window {
+		for k, t := range d.sent {
+			if now.Sub(t) >= d.window {
+				delete(d.sent, k)
+			}
+		}
+		d.swept = now
+	}
+	if t, ok := d.sent[key]; ok && now.Sub(t) < d.window {
+		return false
+	}
+	d.sent[key] = now
+	return true
+}
+
+// forget removes the record made by allow at now for key, so an event that
+// failed to be written is not taken for a duplicate.
+func (d *eventDeduper) forget(key string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := d.sent[key]; ok && t.Equal(now) {
+		delete(d.sent, key)
+	}
+}
+
 // Detailed options for Event generation
 type EventOpts struct {
 	DateHappened                         time.Time
@@ -138,43 +1203,159 @@ type EventOpts struct {
 	Host, AggregationKey, SourceTypeName string
 	Tags                                 []string
 	AlertType                            AlertType
+	// Mentions are appended to the text as @handle, e.g. "slack-ops" or
+	// "@pagerduty", to notify them of the event
+	Mentions []string
 }
 
-func newDefaultEventOpts(alertType AlertType, tags []string, namespace string) *EventOpts {
+// EventOption sets a field of the EventOpts built by Info, Success, Warning and Error.
+type EventOption func(*EventOpts)
+
+// WithPriority sets the event priority.
+func WithPriority(p PriorityType) EventOption {
+	return func(eo *EventOpts) { eo.Priority = p }
+}
+
+// WithAggregationKey sets the key used to group the event with related events.
+func WithAggregationKey(key string) EventOption {
+	return func(eo *EventOpts) { eo.AggregationKey = key }
+}
+
+// WithHost sets the host the event is attributed to.
+func WithHost(host string) EventOption {
+	return func(eo *EventOpts) { eo.Host = host }
+}
+
+// WithSourceTypeName sets the event source, overriding the one derived from the namespace.
+func WithSourceTypeName(source string) EventOption {
+	return func(eo *EventOpts) { eo.SourceTypeName = source }
+}
+
+// WithMentions adds handles to notify of the event, such as "slack-ops".
+func WithMentions(handles ...string) EventOption {
+	return func(eo *EventOpts) { eo.Mentions = append(eo.Mentions, handles...) }
+}
+
+// WithDateHappened sets the time the event happened.
+func WithDateHappened(t time.Time) EventOption {
+	return func(eo *EventOpts) { eo.DateHappened = t }
+}
+
+func newDefaultEventOpts(alertType AlertType, tags []string, source, host string, opts []EventOption) *EventOpts {
 	eo := EventOpts{
-		AlertType: alertType,
-		Tags:      tags,
+		AlertType:      alertType,
+		Tags:           tags,
+		Host:           host,
+		SourceTypeName: source,
 	}
-	// Use the given client namespace as the source type name, if given
-	if namespace != "" {
-		source := namespace
-		if period := strings.IndexByte(source, '.'); period > -1 {
-			source = source[:period]
-		}
-		eo.SourceTypeName = source
+	for _, opt := range opts {
+		opt(&eo)
 	}
 	return &eo
 }
 
 // Event posts to the Datadog event stream.
 // Four event types are supported: info, success, warning, error.
-// If client Namespace is set it is used as the Event source.
-func (c *client) Info(title string, text string, tags []string) error {
-	return c.Event(title, text, newDefaultEventOpts(Info, tags, c.namespace))
+// The Event source is the one set by RegisterEventSource, or else the first
+// component of the client Namespace, if set.
+// If the client was created with Options.EventHost the local hostname is sent as the Event host.
+// Further fields can be set with EventOptions, e.g. c.Error(title, text, tags, WithPriority(Low)).
+func (c *client) Info(title string, text string, tags []string, opts ...EventOption) error {
+	return c.Event(title, text, newDefaultEventOpts(Info, tags, c.defaultEventSource(), c.eventHost, opts))
 }
-func (c *client) Success(title string, text string, tags []string) error {
-	return c.Event(title, text, newDefaultEventOpts(Success, tags, c.namespace))
+func (c *client) Success(title string, text string, tags []string, opts ...EventOption) error {
+	return c.Event(title, text, newDefaultEventOpts(Success, tags, c.defaultEventSource(), c.eventHost, opts))
 }
-func (c *client) Warning(title string, text string, tags []string) error {
-	return c.Event(title, text, newDefaultEventOpts(Warning, tags, c.namespace))
+func (c *client) Warning(title string, text string, tags []string, opts ...EventOption) error {
+	return c.Event(title, text, newDefaultEventOpts(Warning, tags, c.defaultEventSource(), c.eventHost, opts))
 }
-func (c *client) Error(title string, text string, tags []string) error {
-	return c.Event(title, text, newDefaultEventOpts(Error, tags, c.namespace))
+func (c *client) Error(title string, text string, tags []string, opts ...EventOption) error {
+	return c.Event(title, text, newDefaultEventOpts(Error, tags, c.defaultEventSource(), c.eventHost, opts))
 }
 func (c *client) Event(title string, text string, eo *EventOpts) error {
+	return c.event(title, text, eo, false)
+}
+
+// event sends an event. If truncateText is set, text is shortened as needed
+// to keep the payload within maxEventBytes.
+func (c *client) event(title string, text string, eo *EventOpts, truncateText bool) error {
+	if c.off() {
+		return nil
+	}
+	if eo.SourceTypeName == "" {
+		c.mu.RLock()
+		source := c.eventSource
+		c.mu.RUnlock()
+		if source != "" {
+			withSource := *eo
+			withSource.SourceTypeName = source
+			eo = &withSource
+		}
+	}
+	if c.eventTextMaxBytes > 0 && len(text) > c.eventTextMaxBytes {
+		if c.eventTextMaxBytes < len(truncationMarker) {
+			text = truncate(text, c.eventTextMaxBytes)
+		} else {
+			text = truncate(text, c.eventTextMaxBytes-len(truncationMarker)) + truncationMarker
+		}
+	}
+	for _, handle := range eo.Mentions {
+		if text != "" {
+			text += " "
+		}
+		text += "@" + strings.TrimPrefix(handle, "@")
+	}
+	tags := c.mergeTags(eo.Tags)
+	if c.maxTags >= 0 && len(tags) > c.maxTags {
+		return fmt.Errorf("Event '%s' has %d tags, more than the limit of %d, event discarded", title, len(tags), c.maxTags)
+	}
+
+	bytes := c.encodeEvent(title, text, eo, tags)
+	if excess := len(bytes) - maxEventBytes; excess > 0 && truncateText {
+		text = truncate(text, len(text)-excess-len(truncationMarker)) + truncationMarker
+		bytes = c.encodeEvent(title, text, eo, tags)
+	}
+	if len(bytes) > maxEventBytes {
+		return fmt.Errorf("Event '%s' payload is too big (more that 8KB), event discarded", title)
+	}
+	// Rate limited events are not recorded as sent, so they are not taken
+	// for duplicates when retried.
+	if c.eventLimiter != nil && !c.eventLimiter.allow(c.now()) {
+		return ErrEventRateLimited
+	}
+	if c.eventDeduper == nil {
+		return c.writeEvent(bytes)
+	}
+	aggregationKey := eo.AggregationKey
+	if aggregationKey == "" {
+		aggregationKey = c.aggregationKey
+	}
+	key := title + "\x00" + text + "\x00" + aggregationKey
+	now := c.now()
+	if !c.eventDeduper.allow(key, now) {
+		if c.eventDeduper.metric != "" {
+			c.Count(c.eventDeduper.metric, 1, eo.Tags, 1)
+		}
+		return ErrEventDuplicate
+	}
+	if err := c.writeEvent(bytes); err != nil {
+		c.eventDeduper.forget(key, now)
+		return err
+	}
+	return nil
+}
+
+// encodeEvent formats an event with the given merged tags. The alert type
+// always follows the text, then the optional fields come in a fixed order
+// with the tags last, each only when set:
+//
+//	_e{<title length>,<text length>}:<title>|<text>|t:<alert type>|s:<source>|d:<date>|p:<priority>|h:<host>|k:<key>|#<tags>
+func (c *client) encodeEvent(title string, text string, eo *EventOpts, tags []string) []byte {
+	if c.datadogGoCompat {
+		return c.encodeDatadogGoEvent(title, text, eo, tags)
+	}
 	var b bytes.Buffer
-	fmt.Fprintf(&b, "_e{%d,%d}:%s|%s|t:%s", utf8.RuneCountInString(title),
-		utf8.RuneCountInString(text), title, text, eo.AlertType)
+	fmt.Fprintf(&b, "_e{%d,%d}:%s|%s|t:%s", len(title), len(text), title, text, eo.AlertType)
 
 	if eo.SourceTypeName != "" {
 		fmt.Fprintf(&b, "|s:%s", eo.SourceTypeName)
@@ -188,44 +1369,500 @@ func (c *client) Event(title string, text string, eo *EventOpts) error {
 	if eo.Host != "" {
 		fmt.Fprintf(&b, "|h:%s", eo.Host)
 	}
-	if eo.AggregationKey != "" {
-		fmt.Fprintf(&b, "|k:%s", eo.AggregationKey)
+	aggregationKey := eo.AggregationKey
+	if aggregationKey == "" {
+		aggregationKey = c.aggregationKey
+	}
+	if aggregationKey != "" {
+		fmt.Fprintf(&b, "|k:%s", aggregationKey)
 	}
-	tags := append(c.tags, eo.Tags...)
 	format := "|#%s"
 	for _, t := range tags {
 		fmt.Fprintf(&b, format, t)
 		format = ",%s"
 	}
+	return b.Bytes()
+}
 
-	bytes := b.Bytes()
-	if len(bytes) > maxEventBytes {
-		return fmt.Errorf("Event '%s' payload is too big (more that 8KB), event discarded", title)
+// truncationMarker ends text shortened to fit an event.
+const truncationMarker = "..."
+
+// truncate returns the longest prefix of s at most n bytes long that does
+// not split a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// ErrorEvent posts an error event for err. The title is the type of the
+// first error in the chain wrapped by err that is not a plain error from the
+// fmt or errors packages, e.g. "*fs.PathError", or "error" if there is none.
+// The text is err.Error(), shortened and ended with "..." if the event would
+// exceed the 8KB limit. Nothing is sent if err is nil.
+func (c *client) ErrorEvent(err error, tags []string) error {
+	if err == nil {
+		return nil
+	}
+	title := "error"
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if t := fmt.Sprintf("%T", e); !strings.HasPrefix(t, "*fmt.") && !strings.HasPrefix(t, "*errors.") {
+			title = t
+			break
+		}
+	}
+	return c.event(title, err.Error(), newDefaultEventOpts(Error, tags, c.defaultEventSource(), c.eventHost, nil), true)
+}
+
+// DeployEvent posts an info event marking the deploy of version of service,
+// titled "Deployed <service> <version>", with no text. It is tagged with
+// tags followed by service:<service> and version:<version>, the tags
+// Datadog uses to tie deploys to the metrics of a service, and aggregated
+// under the key service so that the deploys of a service are grouped
+// together. The defaults can be overridden with opts, e.g.
+// c.DeployEvent("billing", "v1.2.3", nil, WithAggregationKey("billing-eu")).
+func (c *client) DeployEvent(service, version string, tags []string, opts ...EventOption) error {
+	tags = append(append(make([]string, 0, len(tags)+2), tags...), "service:"+service, "version:"+version)
+	opts = append([]EventOption{WithAggregationKey(service)}, opts...)
+	return c.Event("Deployed "+service+" "+version, "", newDefaultEventOpts(Info, tags, c.defaultEventSource(), c.eventHost, opts))
+}
+
+// writeEvent writes an event, retrying writes to a stream that time out.
+// A retry resumes after the bytes already written, so that an event cut
+// short by the timeout is completed rather than followed by a second copy.
+func (c *client) writeEvent(data []byte) error {
+	if c.queue != nil {
+		return c.write(data)
+	}
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if c.stream {
+		data = append(data, c.lineSeparator...)
+	}
+	n, err := c.conn.Write(data)
+	backoff := c.eventRetryBackoff
+	for i := 0; i < c.eventRetries && isTimeout(err); i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		data = data[n:]
+		n, err = c.conn.Write(data)
 	}
-	_, err := c.conn.Write(bytes)
 	return err
 }
 
+// streamConn writes each payload in full to a stream connection, whose
+// Write may write only part of it. A partial payload would corrupt every
+// line after it, while datagram writes are always all or nothing.
+type streamConn struct {
+	Transport
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := c.Transport.Write(p[written:])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+// deadliner is implemented by connections supporting write deadlines.
+type deadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+// deadlineConn sets a write deadline of timeout before every write.
+type deadlineConn struct {
+	Transport
+	d       deadliner
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	if err := c.d.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Transport.Write(p)
+}
+
+// isTimeout reports whether err is a network timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// MetricType is the kind of a DogStatsD metric.
+type MetricType string
+
+const (
+	Gauge        MetricType = "gauge"
+	Count        MetricType = "count"
+	Histogram    MetricType = "histogram"
+	Distribution MetricType = "distribution"
+	Timing       MetricType = "timing"
+	Set          MetricType = "set"
+)
+
+// suffix returns the DogStatsD type field for t.
+func (t MetricType) suffix() (string, error) {
+	switch t {
+	case Gauge:
+		return "g", nil
+	case Count:
+		return "c", nil
+	case Histogram:
+		return "h", nil
+	case Distribution:
+		return "d", nil
+	case Timing:
+		return "ms", nil
+	case Set:
+		return "s", nil
+	}
+	return "", fmt.Errorf("Unknown metric type '%s'", t)
+}
+
+// Submit sends a metric of the given type. Timing values are in milliseconds.
+func (c *client) Submit(mtype MetricType, name string, value float64, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	return c.send(mtype, name, c.formatFloat(value), tags, rate)
+}
+
+// SampleRate is the fraction of metrics sent, from 0 to 1. Methods taking a
+// SampleRate rather than a float64 make the trailing rate argument explicit
+// at the call site.
+type SampleRate float64
+
+// FullSampleRate sends every metric. It is untyped so that it can also be
+// passed to the methods taking a float64 rate, as in
+// c.Gauge("x", v, tags, dogstatsd.FullSampleRate).
+const FullSampleRate = 1.0
+
+// SubmitSampled is like Submit but takes the rate as a SampleRate.
+func (c *client) SubmitSampled(mtype MetricType, name string, value float64, tags []string, rate SampleRate) error {
+	return c.Submit(mtype, name, value, tags, float64(rate))
+}
+
+// CountSampled is like Count but takes the rate as a SampleRate.
+func (c *client) CountSampled(name string, value int64, tags []string, rate SampleRate) error {
+	return c.Count(name, value, tags, float64(rate))
+}
+
+// formatFloat formats v with the client's float precision.
+func (c *client) formatFloat(v float64) string {
+	if c.precision < 0 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', c.precision, 64)
+}
+
 // Gauges measure the value of a metric at a particular time
 func (c *client) Gauge(name string, value float64, tags []string, rate float64) error {
-	stat := fmt.Sprintf("%f|g", value)
-	return c.send(name, stat, tags, rate)
+	return c.Submit(Gauge, name, value, tags, rate)
+}
+
+// GaugeBool sends a gauge of 1 if value is true and 0 if it is false, e.g.
+// for the state of a feature flag or circuit breaker.
+func (c *client) GaugeBool(name string, value bool, tags []string, rate float64) error {
+	if value {
+		return c.Gauge(name, 1, tags, rate)
+	}
+	return c.Gauge(name, 0, tags, rate)
+}
+
+// GaugeProbabilistic sends a gauge with probability prob, e.g. 0.1 for one
+// call in ten, for occasional diagnostic values. Unlike a rate below 1, the
+// gauge is sent without the |@ annotation, so the agent does not know the
+// value was sampled and doesn't scale anything: skipped calls are simply
+// missing. Use a rate when the agent should account for the calls that
+// were not sent, as for counts.
+func (c *client) GaugeProbabilistic(name string, value float64, prob float64, tags []string) error {
+	if prob < 1 && c.random() >= prob {
+		return nil
+	}
+	return c.Gauge(name, value, tags, 1)
+}
+
+// GaugeNow is like Gauge but, on an asynchronous client (Options.QueueSize),
+// writes the gauge to the connection in the calling goroutine instead of
+// queueing it, for rare metrics that must not wait behind bulk traffic. The
+// gauge therefore overtakes any metrics still in the queue: the agent may
+// receive it before metrics sent earlier with the queued methods, though it
+// is never reordered with other GaugeNow or CountNow calls from the same
+// goroutine. On a synchronous client it is the same as Gauge.
+func (c *client) GaugeNow(name string, value float64, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	return c.sendNow(Gauge, name, c.formatFloat(value), tags, rate)
+}
+
+// GaugeBytes sends the size of v in bytes as a gauge, e.g. for the size of a
+// response body. Strings, byte slices, *bytes.Buffer, *bytes.Reader,
+// *strings.Builder and *strings.Reader are measured without copying, the
+// readers by their unread bytes. Other values, including those whose Len
+// method counts elements rather than bytes, such as sort.IntSlice, are
+// marshaled to measure them: with their MarshalBinary method if they
+// implement encoding.BinaryMarshaler and as JSON otherwise, which costs as
+// much as encoding them, so prefer passing the encoded data when it is at
+// hand. Values that can't be marshaled, such as channels, and nil are
+// discarded with an error.
+func (c *client) GaugeBytes(name string, v interface{}, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	var n int
+	switch v := v.(type) {
+	case string:
+		n = len(v)
+	case []byte:
+		n = len(v)
+	case json.RawMessage:
+		n = len(v)
+	case *bytes.Buffer:
+		n = v.Len()
+	case *bytes.Reader:
+		n = v.Len()
+	case *strings.Builder:
+		n = v.Len()
+	case *strings.Reader:
+		n = v.Len()
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("Gauge '%s' could not marshal %T: %w", name, v, err)
+		}
+		n = len(data)
+	case nil:
+		return fmt.Errorf("Gauge '%s' requires a value to measure, got nil", name)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("Gauge '%s' could not marshal %T: %w", name, v, err)
+		}
+		n = len(data)
+	}
+	return c.Gauge(name, float64(n), tags, rate)
+}
+
+// Number is the set of numeric types accepted by GaugeN.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// GaugeN sends a gauge of any numeric type through c, converting it to a
+// float64 so that call sites don't need to. Go methods can't take type
+// parameters, so it is a function rather than a method of Client.
+func GaugeN[T Number](c Client, name string, value T, tags []string, rate float64) error {
+	return c.Gauge(name, float64(value), tags, rate)
 }
 
 // Counters track how many times something happened per second
 func (c *client) Count(name string, value int64, tags []string, rate float64) error {
-	stat := fmt.Sprintf("%d|c", value)
-	return c.send(name, stat, tags, rate)
+	if c.off() {
+		return nil
+	}
+	return c.send(Count, name, fmt.Sprintf("%d", value), tags, rate)
+}
+
+// CountNow is like Count but bypasses the queue of an asynchronous client,
+// with the same ordering caveats as GaugeNow.
+func (c *client) CountNow(name string, value int64, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	return c.sendNow(Count, name, strconv.FormatInt(value, 10), tags, rate)
+}
+
+// CountFloat sends a count with a fractional value, e.g. for weighted
+// events. The value is sent in its shortest exact form, ignoring the float
+// precision, so that weights like 0.1 add up exactly on the agent.
+func (c *client) CountFloat(name string, value float64, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	return c.send(Count, name, strconv.FormatFloat(value, 'g', -1, 64), tags, rate)
+}
+
+// CountLen sends the length of v, a slice, array, map, string or channel, as
+// a count. Common types are handled without reflection. Other types are
+// discarded with an error.
+func (c *client) CountLen(name string, v interface{}, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	var n int
+	switch v := v.(type) {
+	case string:
+		n = len(v)
+	case []byte:
+		n = len(v)
+	case []string:
+		n = len(v)
+	case []int:
+		n = len(v)
+	case []float64:
+		n = len(v)
+	case []interface{}:
+		n = len(v)
+	case map[string]string:
+		n = len(v)
+	case map[string]interface{}:
+		n = len(v)
+	default:
+		switch rv := reflect.ValueOf(v); rv.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map, reflect.String, reflect.Chan:
+			n = rv.Len()
+		default:
+			return fmt.Errorf("Count '%s' requires a slice, array, map, string or channel, got %T", name, v)
+		}
+	}
+	return c.Count(name, int64(n), tags, rate)
 }
 
 // Histograms track the statistical distribution of a set of values
 func (c *client) Histogram(name string, value float64, tags []string, rate float64) error {
-	stat := fmt.Sprintf("%f|h", value)
-	return c.send(name, stat, tags, rate)
+	return c.Submit(Histogram, name, value, tags, rate)
+}
+
+// HistogramCount sends value as count observations of a histogram, for a
+// pre-aggregated value such as the mean latency of count requests. The
+// observations are packed into multi-value lines, "name:v:v:v|h", so that
+// Datadog counts and averages them correctly without count separate
+// payloads. The line grows with count and is split across several packed
+// lines and packets when needed, all of them sampled together so that either
+// every observation or none is sent. Unlike sending the raw samples, every
+// observation has the same value, so percentiles lose the spread of the
+// original data; prefer Histogram when the samples are available. NaN and
+// infinities are discarded with an error.
+func (c *client) HistogramCount(name string, value float64, count int, tags []string, rate float64) error {
+	if count < 1 {
+		return fmt.Errorf("Histogram '%s' requires a count of at least one", name)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("Histogram '%s' value %v is not a finite number", name, value)
+	}
+	v := c.formatFloat(value)
+	perLine := max(1, maxPacketBytes/2/(len(v)+1))
+	var metrics []batchMetric
+	for count > 0 {
+		n := min(count, perLine)
+		metrics = append(metrics, batchMetric{mtype: Histogram, name: name, value: strings.Repeat(v+":", n-1) + v, tags: tags})
+		count -= n
+	}
+	return c.submitSampled(metrics, rate)
+}
+
+// Timers track how long something took, in milliseconds. Datadog aggregates
+// them like histograms but reports them with the timer type, so use Timer for
+// durations and Histogram for other distributions such as payload sizes.
+func (c *client) Timer(name string, value float64, tags []string, rate float64) error {
+	return c.Submit(Timing, name, value, tags, rate)
+}
+
+// Timing sends a duration as a timer in milliseconds
+func (c *client) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return c.Timer(name, durationMs(value), tags, rate)
+}
+
+// TimingMicros is like Timing but always sends at least three decimals, so
+// that microseconds are kept even with a lower Options.FloatPrecision. The
+// value is still in milliseconds: Datadog takes timer values as float
+// milliseconds, so 0.250 is reported as a quarter of a millisecond in
+// every aggregation, including percentiles.
+func (c *client) TimingMicros(name string, value time.Duration, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	precision := c.precision
+	if precision >= 0 && precision < 3 {
+		precision = 3
+	}
+	return c.send(Timing, name, strconv.FormatFloat(durationMs(value), 'f', precision, 64), tags, rate)
+}
+
+// Mark returns the current time as the start of a span to be measured later
+// with MeasureSince, possibly from another goroutine. The name only labels
+// the mark at the call site; nothing is sent or kept.
+func (c *client) Mark(name string) time.Time {
+	return c.now()
+}
+
+// MeasureSince sends the time elapsed since mark, as returned by Mark, as a
+// timer in milliseconds.
+func (c *client) MeasureSince(name string, mark time.Time, tags []string) error {
+	return c.Timing(name, c.now().Sub(mark), tags, 1)
+}
+
+// DistributionDuration sends a duration as a distribution in milliseconds.
+// Unlike timers, distributions are aggregated globally by Datadog, so use it
+// for latency percentiles across all hosts.
+func (c *client) DistributionDuration(name string, value time.Duration, tags []string, rate float64) error {
+	return c.Submit(Distribution, name, durationMs(value), tags, rate)
+}
+
+// durationMs converts d to fractional milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
 }
 
 // Sets count the number of unique elements in a group
 func (c *client) Set(name string, value string, tags []string, rate float64) error {
-	stat := fmt.Sprintf("%s|s", value)
-	return c.send(name, stat, tags, rate)
+	return c.send(Set, name, value, tags, rate)
+}
+
+// setValueReplacer replaces the characters that would split a packed set value.
+var setValueReplacer = strings.NewReplacer(":", "_", "|", "_", "\n", "_")
+
+// SetValues sends several elements of a set in a single packed line
+func (c *client) SetValues(name string, values []string, tags []string, rate float64) error {
+	if len(values) == 0 {
+		return fmt.Errorf("Set '%s' requires at least one value", name)
+	}
+	sanitized := make([]string, len(values))
+	for i, v := range values {
+		sanitized[i] = setValueReplacer.Replace(v)
+	}
+	return c.send(Set, name, strings.Join(sanitized, ":"), tags, rate)
+}
+
+// GaugeOnChange sends a gauge only if value differs from the last value sent
+// by GaugeOnChange for the same name and tags, or if that value was sent a
+// flush interval or more ago. The agent reports a gauge only in intervals
+// where it received a value, so an unchanged value is still sent once per
+// interval to keep the series from going missing.
+func (c *client) GaugeOnChange(name string, value float64, tags []string) error {
+	if c.off() {
+		return nil
+	}
+	key := c.seriesKey(name, tags)
+	now := c.now()
+	c.lastGauges.mu.Lock()
+	defer c.lastGauges.mu.Unlock()
+	if last, ok := c.lastGauges.values[key]; ok && last.value == value && now.Sub(last.sent) < c.flushInterval {
+		return nil
+	}
+	if err := c.Gauge(name, value, tags, 1); err != nil {
+		return err
+	}
+	c.lastGauges.values[key] = lastGauge{value, now}
+	return nil
 }
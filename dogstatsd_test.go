@@ -4,11 +4,21 @@ package dogstatsd
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
+	"os"
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 var dogstatsdTests = []struct {
@@ -29,9 +39,15 @@ var dogstatsdTests = []struct {
 	{"", nil, "Count", "test.count", int64(1), []string{"tagA"}, 1.0, "test.count:1|c|#tagA"},
 	{"", nil, "Count", "test.count", int64(-1), []string{"tagA"}, 1.0, "test.count:-1|c|#tagA"},
 	{"", nil, "Histogram", "test.histogram", 2.3, []string{"tagA"}, 1.0, "test.histogram:2.300000|h|#tagA"},
+	{"", nil, "Timer", "test.timer", 12.5, []string{"tagA"}, 1.0, "test.timer:12.500000|ms|#tagA"},
+	{"", nil, "Timing", "test.timing", 1500 * time.Microsecond, []string{"tagA"}, 1.0, "test.timing:1.500000|ms|#tagA"},
+	{"", nil, "DistributionDuration", "test.latency", 2 * time.Second, []string{"tagA"}, 1.0, "test.latency:2000.000000|d|#tagA"},
 	{"", nil, "Set", "test.set", "uuid", []string{"tagA"}, 1.0, "test.set:uuid|s|#tagA"},
 	{"flubber.", nil, "Set", "test.set", "uuid", []string{"tagA"}, 1.0, "flubber.test.set:uuid|s|#tagA"},
+	{"flubber", nil, "Set", "test.set", "uuid", []string{"tagA"}, 1.0, "flubber.test.set:uuid|s|#tagA"},
 	{"", []string{"tagC"}, "Set", "test.set", "uuid", []string{"tagA"}, 1.0, "test.set:uuid|s|#tagC,tagA"},
+	{"", nil, "SetValues", "test.set", []string{"a", "b"}, []string{"tagA"}, 1.0, "test.set:a:b|s|#tagA"},
+	{"", nil, "SetValues", "test.set", []string{"a:1", "b|2", "c\n3"}, nil, 1.0, "test.set:a_1:b_2:c_3|s"},
 }
 
 func TestClient(t *testing.T) {
@@ -63,73 +79,1557 @@ func TestClient(t *testing.T) {
 
 }
 
-type eventTest struct {
-	logEvent func(Client) error
-	expected string
+var submitTests = []struct {
+	Type     MetricType
+	Value    float64
+	Expected string
+}{
+	{Gauge, 1.5, "test.metric:1.500000|g"},
+	{Count, 2, "test.metric:2.000000|c"},
+	{Histogram, 2.3, "test.metric:2.300000|h"},
+	{Distribution, 2.3, "test.metric:2.300000|d"},
+	{Timing, 120, "test.metric:120.000000|ms"},
+	{Set, 7, "test.metric:7.000000|s"},
 }
 
-var eventTests = []eventTest{
-	eventTest{
-		logEvent: func(c Client) error { return c.Warning("title", "text", []string{"tag1", "tag2"}) },
-		expected: "_e{5,4}:title|text|t:warning|s:flubber|#tag1,tag2",
-	},
-	eventTest{
-		logEvent: func(c Client) error { return c.Error("Error!", "some error", []string{"tag3"}) },
-		expected: "_e{6,10}:Error!|some error|t:error|s:flubber|#tag3",
-	},
-	eventTest{
-		logEvent: func(c Client) error { return c.Info("FYI", "note", []string{}) },
-		expected: "_e{3,4}:FYI|note|t:info|s:flubber",
-	},
-	eventTest{
-		logEvent: func(c Client) error { return c.Success("Great News", "hurray", []string{"foo", "bar", "baz"}) },
-		expected: "_e{10,6}:Great News|hurray|t:success|s:flubber|#foo,bar,baz",
-	},
-	eventTest{
-		logEvent: func(c Client) error { return c.Info("Unicode", "世界", []string{}) },
-		// Expect character, not byte lengths
-		expected: "_e{7,2}:Unicode|世界|t:info|s:flubber",
-	},
-	eventTest{
-		logEvent: func(c Client) error {
-			eo := EventOpts{
-				DateHappened:   time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC),
-				Priority:       Normal,
-				Host:           "node.example.com",
-				AggregationKey: "foo",
-				SourceTypeName: "bar",
-				AlertType:      Success,
-			}
-			return c.Event("custom title", "custom body", &eo)
-		},
-		expected: "_e{12,11}:custom title|custom body|t:success|s:bar|d:1411080960|p:normal|h:node.example.com|k:foo",
-	},
+func TestSetValuesEmpty(t *testing.T) {
+	addr := "localhost:1201"
+	client := newClient(t, addr)
+	defer client.Close()
+
+	err := client.SetValues("test.set", nil, nil, 1.0)
+	if err == nil || err.Error() != "Set 'test.set' requires at least one value" {
+		t.Errorf("Expected error for empty values, got %v", err)
+	}
 }
 
-func TestEvent(t *testing.T) {
+func TestSubmit(t *testing.T) {
 	addr := "localhost:1201"
 	server := newServer(t, addr)
 	defer server.Close()
 	client := newClient(t, addr)
-	client.SetNamespace("flubber.")
+	defer client.Close()
 
-	for _, tt := range eventTests {
-		if err := tt.logEvent(client); err != nil {
+	for _, tt := range submitTests {
+		if err := client.Submit(tt.Type, "test.metric", tt.Value, nil, 1.0); err != nil {
 			t.Fatal(err)
 		}
 		message := serverRead(t, server)
-		if message != tt.expected {
-			t.Errorf("Expected: %s. Actual: %s", tt.expected, message)
+		if message != tt.Expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.Expected, message)
 		}
 	}
 
-	var b bytes.Buffer
-	for i := 0; i < maxEventBytes+1; i++ {
-		fmt.Fprintf(&b, "a")
+	err := client.Submit(MetricType("bogus"), "test.metric", 1, nil, 1.0)
+	if err == nil || err.Error() != "Unknown metric type 'bogus'" {
+		t.Errorf("Expected error for unknown metric type, got %v", err)
 	}
-	err := client.Error("too long", b.String(), []string{})
-	if err == nil || err.Error() != "Event 'too long' payload is too big (more that 8KB), event discarded" {
-		t.Errorf("Expected error due to exceeded event byte length")
+}
+
+func TestGaugeProbabilistic(t *testing.T) {
+	r := &ring{buf: make([]string, 100)}
+	c, err := newConnClient(r, Options{SamplingSeed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		c.GaugeProbabilistic("test.never", 1, 0, nil)
+		c.GaugeProbabilistic("test.sometimes", 2, 0.3, nil)
+	}
+	sent := r.contents()
+	if len(sent) == 0 || len(sent) > 60 {
+		t.Errorf("Expected about 30 of 100 gauges sent, got %d", len(sent))
+	}
+	for _, message := range sent {
+		if message != "test.sometimes:2.000000|g" {
+			t.Errorf("Expected an unscaled gauge without a rate, got %s", message)
+		}
+	}
+
+	r = &ring{buf: make([]string, 1)}
+	c, _ = newConnClient(r, Options{})
+	c.GaugeProbabilistic("test.always", 1, 1, nil)
+	if len(r.contents()) != 1 {
+		t.Error("Expected a probability of 1 to always send")
+	}
+}
+
+func TestGaugeN(t *testing.T) {
+	r := NewRecorder(10)
+	type bytes uint32
+	if err := GaugeN(r, "test.gauge", 3, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	GaugeN(r, "test.gauge", int32(-4), nil, 1)
+	GaugeN(r, "test.gauge", float32(0.5), nil, 1)
+	GaugeN(r, "test.gauge", bytes(1024), nil, 1)
+
+	expected := []string{
+		"test.gauge:3.000000|g",
+		"test.gauge:-4.000000|g",
+		"test.gauge:0.500000|g",
+		"test.gauge:1024.000000|g",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestGaugeBool(t *testing.T) {
+	r := NewRecorder(10)
+	if err := r.GaugeBool("test.flag", true, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	r.GaugeBool("test.flag", false, []string{"tagA"}, 1)
+
+	expected := []string{"test.flag:1.000000|g|#tagA", "test.flag:0.000000|g|#tagA"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestHistogramCount(t *testing.T) {
+	r := NewRecorder(100)
+	if err := r.HistogramCount("test.latency", 1.5, 3, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"test.latency:1.500000:1.500000:1.500000|h|#tagA"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	if err := r.HistogramCount("test.latency", 1.5, 1000, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	values := 0
+	for _, payload := range r.Sent()[1:] {
+		if len(payload) > maxPacketBytes {
+			t.Errorf("Expected payloads of at most %d bytes, got %d", maxPacketBytes, len(payload))
+		}
+		for _, line := range strings.Split(payload, "\n") {
+			values += strings.Count(line, ":")
+		}
+	}
+	if values != 1000 {
+		t.Errorf("Expected 1000 packed values, got %d", values)
+	}
+
+	err := r.HistogramCount("test.latency", 1.5, 0, nil, 1)
+	if err == nil || err.Error() != "Histogram 'test.latency' requires a count of at least one" {
+		t.Errorf("Expected error for a zero count, got %v", err)
+	}
+	err = r.HistogramCount("test.latency", math.Inf(1), 3, nil, 1)
+	if err == nil || err.Error() != "Histogram 'test.latency' value +Inf is not a finite number" {
+		t.Errorf("Expected error for an infinity, got %v", err)
+	}
+}
+
+func TestHistogramCountSampled(t *testing.T) {
+	r := &ring{buf: make([]string, 20)}
+	c, err := newConnClient(r, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i := 0; i < 100; i++ {
+		r.n, r.start = 0, 0
+		c.HistogramCount("test.latency", 1.5, 500, nil, 0.5)
+		lines := 0
+		values := 0
+		for _, payload := range r.contents() {
+			for _, line := range strings.Split(payload, "\n") {
+				if !strings.HasSuffix(line, "|h|@0.500000") {
+					t.Fatalf("Expected every line sent at the rate, got %q", line)
+				}
+				lines++
+				values += strings.Count(line, ":")
+			}
+		}
+		if values != 0 && values != 500 {
+			t.Fatalf("Expected all or none of the observations sent, got %d", values)
+		}
+		if values != 0 {
+			if lines < 2 {
+				t.Fatalf("Expected the observations to span several lines, got %d", lines)
+			}
+			kept++
+		}
+	}
+	if kept == 0 || kept == 100 {
+		t.Errorf("Expected about half of the calls to be sent, got %d", kept)
+	}
+}
+
+func TestSendAfterClose(t *testing.T) {
+	r := NewRecorder(10)
+	r.Counter("test.count", nil).Add(1)
+	derived := r.WithTags("tagA")
+	derived.Counter("test.count", nil).Add(1)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := map[string]func(c Client) error{
+		"Gauge":          func(c Client) error { return c.Gauge("test.gauge", 1, nil, 1) },
+		"GaugeBool":      func(c Client) error { return c.GaugeBool("test.gauge", true, nil, 1) },
+		"GaugeOnChange":  func(c Client) error { return c.GaugeOnChange("test.gauge", 1, nil) },
+		"Count":          func(c Client) error { return c.Count("test.count", 1, nil, 1) },
+		"FlushCounter":   func(c Client) error { _, err := c.FlushCounter("test.count", nil); return err },
+		"Histogram":      func(c Client) error { return c.Histogram("test.histogram", 1, nil, 1) },
+		"HistogramCount": func(c Client) error { return c.HistogramCount("test.histogram", 1, 2, nil, 1) },
+		"Timer":          func(c Client) error { return c.Timer("test.timer", 1, nil, 1) },
+		"Timing":         func(c Client) error { return c.Timing("test.timer", time.Second, nil, 1) },
+		"Distribution":   func(c Client) error { return c.DistributionDuration("test.dist", time.Second, nil, 1) },
+		"Set":            func(c Client) error { return c.Set("test.set", "a", nil, 1) },
+		"SetValues":      func(c Client) error { return c.SetValues("test.set", []string{"a"}, nil, 1) },
+		"Submit":         func(c Client) error { return c.Submit(Gauge, "test.gauge", 1, nil, 1) },
+		"RecordHTTP":     func(c Client) error { return c.RecordHTTP("GET", "/", 200, time.Second, nil) },
+		"Info":           func(c Client) error { return c.Info("title", "text", nil) },
+		"Success":        func(c Client) error { return c.Success("title", "text", nil) },
+		"Warning":        func(c Client) error { return c.Warning("title", "text", nil) },
+		"Error":          func(c Client) error { return c.Error("title", "text", nil) },
+		"Event":          func(c Client) error { return c.Event("title", "text", &EventOpts{AlertType: Info}) },
+		"ErrorEvent":     func(c Client) error { return c.ErrorEvent(errors.New("boom"), nil) },
+	}
+	for name, call := range calls {
+		if err := call(r); !errors.Is(err, ErrClientClosed) {
+			t.Errorf("%s: expected ErrClientClosed, got %v", name, err)
+		}
+		if err := call(derived); !errors.Is(err, ErrClientClosed) {
+			t.Errorf("%s on a derived client: expected ErrClientClosed, got %v", name, err)
+		}
+	}
+	if sent := r.Sent(); len(sent) != 0 {
+		t.Errorf("Expected nothing sent after Close, got %q", sent)
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	r := NewRecorder(10)
+	r.Gauge("test.gauge", 1, nil, FullSampleRate)
+	r.SubmitSampled(Histogram, "test.histogram", 2, nil, FullSampleRate)
+	r.CountSampled("test.count", 3, []string{"tagA"}, SampleRate(1))
+	r.CountSampled("test.count", 3, nil, 0)
+
+	expected := []string{
+		"test.gauge:1.000000|g",
+		"test.histogram:2.000000|h",
+		"test.count:3|c|#tagA",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestCountFloat(t *testing.T) {
+	r := NewRecorder(10)
+	if err := r.CountFloat("test.weight", 0.25, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	r.CountFloat("test.weight", 3, nil, 1)
+
+	expected := []string{"test.weight:0.25|c|#tagA", "test.weight:3|c"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestCountLen(t *testing.T) {
+	r := NewRecorder(10)
+	ch := make(chan int, 4)
+	ch <- 1
+	for _, v := range []interface{}{"abc", []string{"a", "b"}, map[int]bool{1: true}, [3]int{}, ch, []struct{}{}} {
+		if err := r.CountLen("test.len", v, nil, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"test.len:3|c",
+		"test.len:2|c",
+		"test.len:1|c",
+		"test.len:3|c",
+		"test.len:1|c",
+		"test.len:0|c",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	err := r.CountLen("test.len", 42, nil, 1)
+	if err == nil || err.Error() != "Count 'test.len' requires a slice, array, map, string or channel, got int" {
+		t.Errorf("Expected error for an unsupported type, got %v", err)
+	}
+	if err := r.CountLen("test.len", nil, nil, 1); err == nil {
+		t.Error("Expected error for nil")
+	}
+}
+
+// binaryValue marshals to a fixed number of bytes.
+type binaryValue struct{}
+
+func (binaryValue) MarshalBinary() ([]byte, error) {
+	return make([]byte, 16), nil
+}
+
+func TestGaugeBytes(t *testing.T) {
+	r := NewRecorder(10)
+	for _, v := range []interface{}{
+		"abc",
+		[]byte("abcd"),
+		json.RawMessage(`{}`),
+		bytes.NewBufferString("abcde"),
+		bytes.NewReader([]byte("ab")),
+		strings.NewReader("abc"),
+		binaryValue{},
+		map[string]int{"a": 1},
+		sort.IntSlice{1000, 2000, 3000},
+	} {
+		if err := r.GaugeBytes("test.size", v, nil, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"test.size:3.000000|g",
+		"test.size:4.000000|g",
+		"test.size:2.000000|g",
+		"test.size:5.000000|g",
+		"test.size:2.000000|g",
+		"test.size:3.000000|g",
+		"test.size:16.000000|g",
+		"test.size:7.000000|g",
+		"test.size:16.000000|g",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	if err := r.GaugeBytes("test.size", make(chan int), nil, 1); err == nil {
+		t.Error("Expected error for a value that can't be marshaled")
+	}
+	if err := r.GaugeBytes("test.size", nil, nil, 1); err == nil {
+		t.Error("Expected error for nil")
+	}
+}
+
+func TestPrecision(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{FloatPrecision: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, tt := range []struct {
+		Client   Client
+		Expected string
+	}{
+		{client, "test.gauge:3.14|g"},
+		{client.WithPrecision(4), "test.gauge:3.1416|g"},
+		{client.WithPrecision(-1), "test.gauge:3.14159265|g"},
+	} {
+		if err := tt.Client.Gauge("test.gauge", 3.14159265, nil, 1.0); err != nil {
+			t.Fatal(err)
+		}
+		if message := serverRead(t, server); message != tt.Expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.Expected, message)
+		}
+	}
+}
+
+func TestTimingMicros(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{FloatPrecision: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.TimingMicros("test.timer", 1234*time.Microsecond, nil, 1)
+	c.Timing("test.timer", 1234*time.Microsecond, nil, 1)
+	c.WithPrecision(-1).TimingMicros("test.timer", 1234567*time.Nanosecond, nil, 1)
+	c.WithPrecision(5).TimingMicros("test.timer", 250*time.Microsecond, nil, 1)
+
+	expected := []string{
+		"test.timer:1.234|ms",
+		"test.timer:1.2|ms",
+		"test.timer:1.234567|ms",
+		"test.timer:0.25000|ms",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+
+	ts := time.Now().Add(-time.Minute)
+	timestamped := client.WithTimestamp(ts)
+	suffix := fmt.Sprintf("|#tagA|T%d", ts.Unix())
+	for _, tt := range []struct {
+		Type     MetricType
+		Expected string
+	}{
+		{Gauge, "test.metric:1.000000|g" + suffix},
+		{Count, "test.metric:1.000000|c" + suffix},
+		{Distribution, "test.metric:1.000000|d" + suffix},
+		{Set, "test.metric:1.000000|s" + suffix},
+	} {
+		if err := timestamped.Submit(tt.Type, "test.metric", 1, []string{"tagA"}, 1.0); err != nil {
+			t.Fatal(err)
+		}
+		if message := serverRead(t, server); message != tt.Expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.Expected, message)
+		}
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	err := client.WithTimestamp(old).Gauge("test.gauge", 1, nil, 1.0)
+	expected := fmt.Sprintf("Metric 'test.gauge' timestamp %d is outside the accepted window, metric discarded", old.Unix())
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error for old timestamp, got %v", err)
+	}
+}
+
+func TestTagPrefix(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{TagPrefix: "myapp."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetTags([]string{"env:prod"})
+
+	if err := client.Count("test.count", 1, []string{"role:web", "dd.internal.entity_id:1"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:1|c|#myapp.env:prod,myapp.role:web,dd.internal.entity_id:1"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+
+	if err := client.Info("title", "text", []string{"role:web"}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "_e{5,4}:title|text|t:info|#myapp.env:prod,myapp.role:web"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestOverrideTags(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{OverrideTags: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTags([]string{"env:staging", "region:us", "canary"})
+
+	c.Count("test.count", 1, []string{"env:test", "canary:false"}, 1)
+	c.Count("test.count", 1, nil, 1)
+	expected := []string{
+		"test.count:1|c|#region:us,env:test,canary:false",
+		"test.count:1|c|#env:staging,region:us,canary",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+	if tags := c.GetTags(); len(tags) != 3 {
+		t.Errorf("Expected global tags to be unchanged, got %q", tags)
+	}
+}
+
+func TestVersionTag(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{VersionTag: true, TagPrefix: "myapp."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Count("test.count", 1, []string{"tagA"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:1|c|#myapp.tagA,dd.internal.client_version:" + Version
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestMetadataTags(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{MetadataTags: true, AppVersion: "1.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Count("test.count", 1, []string{"tagA"}, 1)
+	expected := []string{fmt.Sprintf("test.count:1|c|#host:%s,pid:%d,version:1.2.3,tagA", hostname, os.Getpid())}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestDefaultHostTag(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{DefaultHostTag: "web1", MetadataTags: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Count("test.count", 1, []string{"tagA"}, 1)
+	c.Info("title", "text", nil)
+
+	expected := []string{
+		fmt.Sprintf("test.count:1|c|#pid:%d,tagA,host:web1", os.Getpid()),
+		fmt.Sprintf("_e{5,4}:title|text|t:info|#pid:%d", os.Getpid()),
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestCallerTag(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{CallerTag: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, line, _ := runtime.Caller(0)
+	c.Timing("test.timer", time.Millisecond, []string{"tagA"}, 1)
+	c.WithTags("tagB").Count("test.count", 1, nil, 1)
+
+	expected := []string{
+		fmt.Sprintf("test.timer:1.000000|ms|#tagA,caller:dogstatsd_test.go:%d", line+1),
+		fmt.Sprintf("test.count:1|c|#tagB,caller:dogstatsd_test.go:%d", line+2),
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestNamespaceSeparator(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{NamespaceSeparator: "_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, namespace := range []string{"flubber", "flubber_"} {
+		client.SetNamespace(namespace)
+		if err := client.Count("count", 1, nil, 1.0); err != nil {
+			t.Fatal(err)
+		}
+		expected := "flubber_count:1|c"
+		if message := serverRead(t, server); message != expected {
+			t.Errorf("Expected: %s. Actual: %s", expected, message)
+		}
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+	client.SetTags([]string{"env:prod"})
+
+	for _, tt := range []struct {
+		Client   Client
+		Expected string
+	}{
+		{client.WithTags("role:web", "az:a"), "test.gauge:1.000000|g|#env:prod,role:web,az:a,tagA"},
+		{client.WithUnit("bytes"), "test.gauge:1.000000|g|#env:prod,unit:bytes,tagA"},
+		{client, "test.gauge:1.000000|g|#env:prod,tagA"},
+	} {
+		if err := tt.Client.Gauge("test.gauge", 1, []string{"tagA"}, 1.0); err != nil {
+			t.Fatal(err)
+		}
+		if message := serverRead(t, server); message != tt.Expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.Expected, message)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	r := NewRecorder(1)
+	c := r.client
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if err := c.WithTimestamp(now.Add(-59*time.Minute)).Gauge("test.gauge", 1, nil, 1.0); err != nil {
+		t.Error(err)
+	}
+	if err := c.WithTimestamp(now.Add(11*time.Minute)).Gauge("test.gauge", 1, nil, 1.0); err == nil {
+		t.Error("Expected error for a timestamp too far in the future")
+	}
+
+	c.eventLimiter = newEventLimiter(1, 1)
+	if err := c.Info("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Info("title", "text", nil); err != ErrEventRateLimited {
+		t.Errorf("Expected ErrEventRateLimited, got %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := c.Info("title", "text", nil); err != nil {
+		t.Errorf("Expected event to be allowed a second later, got %v", err)
+	}
+}
+
+func TestMeasureSince(t *testing.T) {
+	r := NewRecorder(1)
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	r.client.now = func() time.Time { return now }
+
+	mark := r.Mark("request.received")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		now = now.Add(1500 * time.Microsecond)
+		if err := r.MeasureSince("request.duration", mark, []string{"tagA"}); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-done
+
+	expected := []string{"request.duration:1.500000|ms|#tagA"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestGaugeOnChange(t *testing.T) {
+	r := NewRecorder(10)
+	for _, v := range []float64{1, 1, 2, 2, 1} {
+		if err := r.GaugeOnChange("config.version", v, []string{"tagA"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.WithTags("tagB").GaugeOnChange("config.version", 1, []string{"tagA"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"config.version:1.000000|g|#tagA",
+		"config.version:2.000000|g|#tagA",
+		"config.version:1.000000|g|#tagA",
+		"config.version:1.000000|g|#tagB,tagA",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %v. Actual: %v", expected, sent)
+	}
+}
+
+func TestGaugeOnChangeWindow(t *testing.T) {
+	r := NewRecorder(10)
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	for _, step := range []time.Duration{0, 5 * time.Second, 4 * time.Second, time.Second, 9 * time.Second, time.Second} {
+		now = now.Add(step)
+		if err := r.GaugeOnChange("config.version", 1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"config.version:1.000000|g",
+		"config.version:1.000000|g",
+		"config.version:1.000000|g",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %v. Actual: %v", expected, sent)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	r := NewRecorder(1)
+	r.SetNamespace("flubber.")
+	r.SetTags([]string{"env:prod"})
+
+	restore := r.Snapshot()
+	r.SetNamespace("other.")
+	r.SetTags([]string{"env:test"})
+	restore()
+
+	if namespace := r.GetNamespace(); namespace != "flubber." {
+		t.Errorf("Expected namespace flubber., got %s", namespace)
+	}
+	if tags := r.GetTags(); !reflect.DeepEqual(tags, []string{"env:prod"}) {
+		t.Errorf("Expected tags [env:prod], got %v", tags)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			restore := r.Snapshot()
+			r.SetTags([]string{"env:test"})
+			r.Count("test.count", 1, nil, 1.0)
+			restore()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMaxTags(t *testing.T) {
+	r := &ring{buf: make([]string, 1)}
+	c, err := newConnClient(r, Options{MaxTags: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTags([]string{"env:prod"})
+
+	if err := c.Count("test.count", 1, []string{"tagA"}, 1.0); err != nil {
+		t.Error(err)
+	}
+	err = c.Count("test.count", 1, []string{"tagA", "tagB"}, 1.0)
+	if err == nil || err.Error() != "Metric 'test.count' has 3 tags, more than the limit of 2, metric discarded" {
+		t.Errorf("Expected error for too many tags, got %v", err)
+	}
+	err = c.Info("title", "text", []string{"tagA", "tagB"})
+	if err == nil || err.Error() != "Event 'title' has 3 tags, more than the limit of 2, event discarded" {
+		t.Errorf("Expected error for too many tags, got %v", err)
+	}
+
+	if c, err = newConnClient(r, Options{MaxTags: -1}); err != nil {
+		t.Fatal(err)
+	}
+	tags := make([]string, DefaultMaxTags+1)
+	if err := c.Count("test.count", 1, tags, 1.0); err != nil {
+		t.Errorf("Expected no tag limit, got %v", err)
+	}
+}
+
+func TestDerivedClientChain(t *testing.T) {
+	r := NewRecorder(1)
+	r.SetNamespace("flubber")
+	r.SetTags([]string{"env:prod"})
+
+	service := r.WithNamespace("api").WithTags("service:api")
+	handler := service.WithTags("handler:users").WithNamespace("users.")
+	leaf := handler.WithTags("env:test")
+
+	for _, tt := range []struct {
+		Client    Client
+		Namespace string
+		Tags      []string
+	}{
+		{r, "flubber", []string{"env:prod"}},
+		{service, "flubber.api", []string{"env:prod", "service:api"}},
+		{handler, "flubber.api.users.", []string{"env:prod", "service:api", "handler:users"}},
+		{leaf, "flubber.api.users.", []string{"env:prod", "service:api", "handler:users", "env:test"}},
+	} {
+		if namespace := tt.Client.GetNamespace(); namespace != tt.Namespace {
+			t.Errorf("Expected namespace %s, got %s", tt.Namespace, namespace)
+		}
+		if tags := tt.Client.GetTags(); !reflect.DeepEqual(tags, tt.Tags) {
+			t.Errorf("Expected tags %v, got %v", tt.Tags, tags)
+		}
+	}
+
+	if err := leaf.Count("requests", 1, []string{"tagA"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "flubber.api.users.requests:1|c|#env:prod,service:api,handler:users,env:test,tagA"
+	if sent := r.Sent(); sent[0] != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, sent[0])
+	}
+}
+
+func TestDeterministicSampling(t *testing.T) {
+	r := &ring{buf: make([]string, 1000)}
+	c, err := newConnClient(r, Options{Sampling: SampleDeterministic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := 0
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("test.count.%d", i)
+		for j := 0; j < 5; j++ {
+			if err := c.Count(name, 1, []string{"tagA"}, 0.5); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if sent := len(r.contents()); sent != kept && sent != kept+5 {
+			t.Fatalf("Expected %s to be always or never sent, got %d of 5", name, sent-kept)
+		} else {
+			kept = sent
+		}
+	}
+	if kept == 0 || kept == 1000 {
+		t.Errorf("Expected some series to be sampled out, got %d of 1000 metrics sent", kept)
+	}
+}
+
+func TestLocalScaling(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{LocalScaling: true, Sampling: SampleDeterministic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Find a series kept at a rate of 0.3.
+	name := ""
+	for i := 0; name == ""; i++ {
+		if n := fmt.Sprintf("test.count.%d", i); sampleByHash(n, nil, 0.3) {
+			name = n
+		}
+	}
+
+	c.Count(name, 2, nil, 0.3)
+	c.Histogram(name, 2, nil, 0.3)
+	c.Count(name, 2, nil, 1)
+	expected := []string{
+		name + ":7|c",
+		name + ":2.000000|h|@0.300000",
+		name + ":2|c",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestSamplingSeed(t *testing.T) {
+	run := func() []string {
+		r := &ring{buf: make([]string, 100)}
+		c, err := newConnClient(r, Options{SamplingSeed: 42})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			c.Count("test.count", int64(i), nil, 0.5)
+		}
+		return r.contents()
+	}
+	first, second := run(), run()
+	if len(first) == 0 || len(first) == 100 {
+		t.Errorf("Expected some metrics to be sampled out, got %d of 100 sent", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Error("Expected the same metrics to be kept with the same seed")
+	}
+}
+
+func TestShardTag(t *testing.T) {
+	// Pin the hash so that shards stay stable across versions.
+	for key, expected := range map[string]string{"": "shard:5", "user-1": "shard:4", "user-2": "shard:13"} {
+		if tag := ShardTag(key, 16); tag != expected {
+			t.Errorf("Expected ShardTag(%q, 16) to be %s, got %s", key, expected, tag)
+		}
+	}
+	if tag := ShardTag("user-1", 0); tag != "shard:0" {
+		t.Errorf("Expected a single shard, got %s", tag)
+	}
+
+	counts := make([]int, 4)
+	for i := 0; i < 4000; i++ {
+		var n int
+		fmt.Sscanf(ShardTag(fmt.Sprintf("key-%d", i), 4), "shard:%d", &n)
+		counts[n]++
+	}
+	for n, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Errorf("Expected about 1000 keys in shard %d, got %d", n, count)
+		}
+	}
+}
+
+func TestCardinality(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{Cardinality: CardinalityLow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Gauge("test.gauge", 1.0, []string{"tagA"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.gauge:1.000000|g|#tagA|card:low"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+
+	if err := client.WithCardinality(CardinalityHigh).Count("test.count", 1, nil, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected = "test.count:1|c|card:high"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+type eventTest struct {
+	logEvent func(Client) error
+	expected string
+}
+
+var eventTests = []eventTest{
+	eventTest{
+		logEvent: func(c Client) error { return c.Warning("title", "text", []string{"tag1", "tag2"}) },
+		expected: "_e{5,4}:title|text|t:warning|s:flubber|#tag1,tag2",
+	},
+	eventTest{
+		logEvent: func(c Client) error { return c.Error("Error!", "some error", []string{"tag3"}) },
+		expected: "_e{6,10}:Error!|some error|t:error|s:flubber|#tag3",
+	},
+	eventTest{
+		logEvent: func(c Client) error { return c.Info("FYI", "note", []string{}) },
+		expected: "_e{3,4}:FYI|note|t:info|s:flubber",
+	},
+	eventTest{
+		logEvent: func(c Client) error { return c.Success("Great News", "hurray", []string{"foo", "bar", "baz"}) },
+		expected: "_e{10,6}:Great News|hurray|t:success|s:flubber|#foo,bar,baz",
+	},
+	eventTest{
+		logEvent: func(c Client) error { return c.Info("Unicode", "世界", []string{}) },
+		// Expect byte, not character lengths
+		expected: "_e{7,6}:Unicode|世界|t:info|s:flubber",
+	},
+	eventTest{
+		logEvent: func(c Client) error { return c.Info("Deploy 🚀", "done ✅", []string{}) },
+		expected: "_e{11,8}:Deploy 🚀|done ✅|t:info|s:flubber",
+	},
+	eventTest{
+		logEvent: func(c Client) error {
+			eo := EventOpts{
+				DateHappened:   time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC),
+				Priority:       Normal,
+				Host:           "node.example.com",
+				AggregationKey: "foo",
+				SourceTypeName: "bar",
+				AlertType:      Success,
+			}
+			return c.Event("custom title", "custom body", &eo)
+		},
+		expected: "_e{12,11}:custom title|custom body|t:success|s:bar|d:1411080960|p:normal|h:node.example.com|k:foo",
+	},
+	eventTest{
+		logEvent: func(c Client) error {
+			return c.Error("Error!", "some error", []string{"tag3"},
+				WithPriority(Low),
+				WithAggregationKey("foo"),
+				WithHost("node.example.com"),
+				WithSourceTypeName("bar"),
+				WithDateHappened(time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)))
+		},
+		expected: "_e{6,10}:Error!|some error|t:error|s:bar|d:1411080960|p:low|h:node.example.com|k:foo|#tag3",
+	},
+}
+
+func TestEvent(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	client.SetNamespace("flubber.")
+
+	for _, tt := range eventTests {
+		if err := tt.logEvent(client); err != nil {
+			t.Fatal(err)
+		}
+		message := serverRead(t, server)
+		if message != tt.expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.expected, message)
+		}
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < maxEventBytes+1; i++ {
+		fmt.Fprintf(&b, "a")
+	}
+	err := client.Error("too long", b.String(), []string{})
+	if err == nil || err.Error() != "Event 'too long' payload is too big (more that 8KB), event discarded" {
+		t.Errorf("Expected error due to exceeded event byte length")
+	}
+}
+
+func TestRegisterEventSource(t *testing.T) {
+	r := NewRecorder(10)
+	r.SetNamespace("flubber.")
+	r.RegisterEventSource("billing.jobs")
+
+	r.Info("title", "text", nil)
+	r.Info("title", "text", nil, WithSourceTypeName("override"))
+	r.Event("title", "text", &EventOpts{AlertType: Success})
+	expected := []string{
+		"_e{5,4}:title|text|t:info|s:billing.jobs",
+		"_e{5,4}:title|text|t:info|s:override",
+		"_e{5,4}:title|text|t:success|s:billing.jobs",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestEventTextMaxBytes(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{EventTextMaxBytes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Info("title", "short", nil)
+	c.Info("title", "héééééé", nil)
+	c.Info("title", strings.Repeat("a", 10000), nil)
+	expected := []string{
+		"_e{5,5}:title|short|t:info",
+		"_e{5,8}:title|héé...|t:info",
+		"_e{5,8}:title|aaaaa...|t:info",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	// A cap too small for the marker cuts the text without it.
+	r = &ring{buf: make([]string, 10)}
+	if c, err = newConnClient(r, Options{EventTextMaxBytes: 2}); err != nil {
+		t.Fatal(err)
+	}
+	c.Info("t", "text", nil)
+	c.Info("t", "é!", nil)
+	expected = []string{"_e{1,2}:t|te|t:info", "_e{1,2}:t|é|t:info"}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestEventSourceFullNamespace(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{EventSourceFullNamespace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetNamespace("billing.jobs.")
+	c.Info("title", "text", nil)
+	c.SetNamespace("billing")
+	c.Info("title", "text", nil)
+
+	expected := []string{
+		"_e{5,4}:title|text|t:info|s:billing_jobs",
+		"_e{5,4}:title|text|t:info|s:billing",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestEventMentions(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{EventTextMaxBytes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Error("deploy", "failed", nil, WithMentions("slack-ops", "@pagerduty"))
+	c.Event("deploy", "", &EventOpts{AlertType: Info, Mentions: []string{"slack-ops"}})
+	c.Error("deploy", "failed on every host", nil, WithMentions("slack-ops"))
+
+	expected := []string{
+		"_e{6,28}:deploy|failed @slack-ops @pagerduty|t:error",
+		"_e{6,10}:deploy|@slack-ops|t:info",
+		"_e{6,19}:deploy|faile... @slack-ops|t:error",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestEventHost(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{EventHost: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Warning("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "_e{5,4}:title|text|t:warning|h:" + hostname
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestEventRateLimit(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{EventsPerSecond: 0.001, EventBurst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := client.Error("title", "text", nil); err != nil {
+			t.Fatal(err)
+		}
+		serverRead(t, server)
+	}
+	if err := client.Error("title", "text", nil); err != ErrEventRateLimited {
+		t.Errorf("Expected ErrEventRateLimited, got %v", err)
+	}
+}
+
+func TestEventLimiterRefill(t *testing.T) {
+	l := newEventLimiter(2, 1)
+	start := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	if !l.allow(start) {
+		t.Fatal("Expected first event to be allowed")
+	}
+	if l.allow(start.Add(100 * time.Millisecond)) {
+		t.Error("Expected event before refill to be limited")
+	}
+	if !l.allow(start.Add(600 * time.Millisecond)) {
+		t.Error("Expected event after refill to be allowed")
+	}
+}
+
+func TestEventDedup(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{EventDedupWindow: time.Minute, EventDedupMetric: "events.suppressed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if err := c.Error("db down", "timeout", []string{"tagA"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Error("db down", "timeout", []string{"tagA"}); err != ErrEventDuplicate {
+		t.Errorf("Expected ErrEventDuplicate, got %v", err)
+	}
+	if err := c.Error("db down", "timeout", nil, WithAggregationKey("db")); err != nil {
+		t.Errorf("Expected an event with another aggregation key to be sent, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := c.Error("db down", "timeout", []string{"tagA"}); err != nil {
+		t.Errorf("Expected the event to be sent after the window, got %v", err)
+	}
+
+	expected := []string{
+		"_e{7,7}:db down|timeout|t:error|#tagA",
+		"events.suppressed:1|c|#tagA",
+		"_e{7,7}:db down|timeout|t:error|k:db",
+		"_e{7,7}:db down|timeout|t:error|#tagA",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+	if n := len(c.eventDeduper.sent); n != 1 {
+		t.Errorf("Expected expired events to be forgotten, got %d", n)
+	}
+}
+
+func TestEventDedupRateLimited(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{EventsPerSecond: 1, EventDedupWindow: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if err := c.Error("db down", "timeout", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Error("cache down", "timeout", nil); err != ErrEventRateLimited {
+		t.Errorf("Expected ErrEventRateLimited, got %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := c.Error("cache down", "timeout", nil); err != nil {
+		t.Errorf("Expected the rate limited event to be sent when retried, got %v", err)
+	}
+
+	expected := []string{
+		"_e{7,7}:db down|timeout|t:error",
+		"_e{10,7}:cache down|timeout|t:error",
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestEventDedupWriteError(t *testing.T) {
+	conn := &flakyConn{failures: 1}
+	c, err := newConnClient(conn, Options{EventDedupWindow: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Error("db down", "timeout", nil); err == nil {
+		t.Fatal("Expected the first write to fail")
+	}
+	if err := c.Error("db down", "timeout", nil); err != nil {
+		t.Errorf("Expected the failed event to be sent when retried, got %v", err)
+	}
+}
+
+func TestEventAggregationKey(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{EventAggregationKey: "deploys"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Info("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "_e{5,4}:title|text|t:info|k:deploys"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+
+	if err := client.Event("title", "text", &EventOpts{AlertType: Info, AggregationKey: "rollback"}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "_e{5,4}:title|text|t:info|k:rollback"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestStreamNetwork(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:1202")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := NewWithOptions(listener.Addr().String(), Options{Network: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := client.Count("test.count", 1, nil, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Info("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:1|c\n_e{5,4}:title|text|t:info\n"
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, buf)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// flakyConn times out on its next failures writes, after writing up to
+// partial bytes of each.
+type flakyConn struct {
+	failures int
+	partial  int
+	written  []string
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	if c.failures > 0 {
+		c.failures--
+		n := min(c.partial, len(p))
+		if n > 0 {
+			c.written = append(c.written, string(p[:n]))
+		}
+		return n, timeoutError{}
+	}
+	c.written = append(c.written, string(p))
+	return len(p), nil
+}
+
+func (c *flakyConn) Close() error {
+	return nil
+}
+
+func TestWriteTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c, err := newConnClient(conn, Options{WriteTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Nothing reads from peer, so the write blocks until the deadline.
+	start := time.Now()
+	err = c.Count("test.count", 1, nil, 1)
+	if !isTimeout(err) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the write to time out after 20ms, took %v", elapsed)
+	}
+
+	go io.ReadAll(peer)
+	if err := c.Count("test.count", 1, nil, 1); err != nil {
+		t.Errorf("Expected the write to succeed with a reader, got %v", err)
+	}
+}
+
+// shortWriter writes at most max bytes per call.
+type shortWriter struct {
+	max int
+	bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.Buffer.Write(p)
+}
+
+func (w *shortWriter) Close() error {
+	return nil
+}
+
+func TestStreamPartialWrites(t *testing.T) {
+	w := &shortWriter{max: 3}
+	c, err := newConnClient(w, Options{Network: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Count("test.count", 1, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Info("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:1|c|#tagA\n_e{5,4}:title|text|t:info\n"
+	if w.String() != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, w.String())
+	}
+
+	c, err = newConnClient(&shortWriter{max: 0}, Options{Network: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Count("test.count", 1, nil, 1); err != io.ErrShortWrite {
+		t.Errorf("Expected io.ErrShortWrite from a writer making no progress, got %v", err)
+	}
+}
+
+func TestLocalAddr(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{LocalAddr: "127.0.0.1:1203"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Count("test.count", 1, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	_, from, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != "127.0.0.1:1203" {
+		t.Errorf("Expected a datagram from 127.0.0.1:1203, got %s", from)
+	}
+
+	if _, err := NewWithOptions(addr, Options{LocalAddr: "127.0.0.1:1203"}); err == nil {
+		t.Error("Expected an error binding a local address in use")
+	}
+	if _, err := NewWithOptions(addr, Options{LocalAddr: "not an address"}); err == nil || !strings.HasPrefix(err.Error(), "Invalid local address") {
+		t.Errorf("Expected an invalid local address error, got %v", err)
+	}
+	if _, err := NewWithOptions("/tmp/dsd.sock", Options{Network: "unixgram", LocalAddr: ":1203"}); err == nil || err.Error() != "Local address is not supported on network 'unixgram'" {
+		t.Errorf("Expected an unsupported network error, got %v", err)
+	}
+}
+
+// chanTransport hands every payload to a channel.
+type chanTransport chan string
+
+func (t chanTransport) Write(p []byte) (int, error) {
+	t <- string(p)
+	return len(p), nil
+}
+
+func (t chanTransport) Close() error {
+	close(t)
+	return nil
+}
+
+func TestNewWithTransport(t *testing.T) {
+	transport := make(chanTransport, 10)
+	c, err := NewWithTransport(transport, Options{Network: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.HistogramWithCount("test.latency", 1, nil, 1)
+	c.Info("title", "text", nil)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	for payload := range transport {
+		sent = append(sent, payload)
+	}
+	expected := []string{
+		"test.latency:1.000000|h\ntest.latency.count:1|c\n",
+		"_e{5,4}:title|text|t:info\n",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+
+	if _, err := NewWithTransport(transport, Options{LineSeparator: "|"}); err == nil {
+		t.Error("Expected an error for invalid options")
+	}
+}
+
+func TestEventRetries(t *testing.T) {
+	conn := &flakyConn{failures: 2}
+	client, err := newConnClient(conn, Options{Network: "tcp", EventRetries: 2, EventRetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Info("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"_e{5,4}:title|text|t:info\n"}; !reflect.DeepEqual(conn.written, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, conn.written)
+	}
+
+	conn.failures = 3
+	if err := client.Info("title", "text", nil); err != (timeoutError{}) {
+		t.Errorf("Expected timeout after exhausting retries, got %v", err)
+	}
+	if err := client.Count("test.count", 1, nil, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	conn.failures = 1
+	if err := client.Count("test.count", 1, nil, 1.0); err != (timeoutError{}) {
+		t.Errorf("Expected metrics not to be retried, got %v", err)
+	}
+}
+
+func TestEventRetryAfterPartialWrite(t *testing.T) {
+	conn := &flakyConn{failures: 2, partial: 5}
+	client, err := newConnClient(conn, Options{Network: "tcp", EventRetries: 2, EventRetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Info("title", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	// The retries complete the event instead of writing it again.
+	expected := "_e{5,4}:title|text|t:info\n"
+	if written := strings.Join(conn.written, ""); written != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, written)
+	}
+}
+
+func TestErrorEvent(t *testing.T) {
+	r := NewRecorder(1)
+	err := fmt.Errorf("loading config: %w", &os.PathError{Op: "open", Path: "/etc/app", Err: os.ErrNotExist})
+	if err := r.ErrorEvent(err, []string{"tagA"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "_e{13,50}:*fs.PathError|loading config: open /etc/app: file does not exist|t:error|#tagA"
+	if sent := r.Sent(); sent[0] != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, sent[0])
+	}
+
+	long := errors.New(strings.Repeat("世", maxEventBytes))
+	if err := r.ErrorEvent(long, nil); err != nil {
+		t.Fatal(err)
+	}
+	sent := r.Sent()[0]
+	if !strings.HasPrefix(sent, "_e{5,") || len(sent) > maxEventBytes || !strings.HasSuffix(sent, "...|t:error") || !utf8.ValidString(sent) {
+		t.Errorf("Expected text to be truncated to fit, got %d bytes ending %q", len(sent), sent[len(sent)-20:])
+	}
+
+	if err := r.ErrorEvent(nil, nil); err != nil {
+		t.Errorf("Expected nil error to send nothing, got %v", err)
+	}
+}
+
+func TestDeployEvent(t *testing.T) {
+	r := NewRecorder(2)
+	r.SetNamespace("flubber.")
+	tags := make([]string, 1, 4)
+	tags[0] = "env:prod"
+	if err := r.DeployEvent("billing", "v1.2.3", tags); err != nil {
+		t.Fatal(err)
+	}
+	r.DeployEvent("billing", "v1.2.4", nil, WithAggregationKey("billing-eu"), WithPriority(Low))
+
+	expected := []string{
+		"_e{23,0}:Deployed billing v1.2.3||t:info|s:flubber|k:billing|#env:prod,service:billing,version:v1.2.3",
+		"_e{23,0}:Deployed billing v1.2.4||t:info|s:flubber|p:low|k:billing-eu|#service:billing,version:v1.2.4",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+	if tags[:2][1] != "" {
+		t.Errorf("Expected the caller's tags to be left alone, got %q", tags[:2])
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct {
+		S        string
+		N        int
+		Expected string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 2, "he"},
+		{"hello", -1, ""},
+		{"世界", 4, "世"},
+		{"世界", 2, ""},
+	} {
+		if actual := truncate(tt.S, tt.N); actual != tt.Expected {
+			t.Errorf("truncate(%q, %d): expected %q, got %q", tt.S, tt.N, tt.Expected, actual)
+		}
 	}
 }
 
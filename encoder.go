@@ -0,0 +1,96 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric is a metric ready to be encoded, with the client namespace, global
+// tags and sampling already applied.
+type Metric struct {
+	Name  string
+	Type  MetricType
+	Value string
+	// Rate is the sample rate the metric was sent at, 1 if it was not sampled
+	Rate float64
+	Tags []string
+	// Timestamp is the time of the metric, zero to use the time it is received
+	Timestamp   time.Time
+	Cardinality Cardinality
+}
+
+// Encoder formats metrics into the payloads sent by a client.
+type Encoder interface {
+	Encode(Metric) []byte
+}
+
+// DogStatsDEncoder encodes metrics in the DogStatsD protocol. The optional
+// fields follow the value and type in the order of the DogStatsD datagram
+// format, each only when set:
+//
+//	<name>:<value>|<type>|@<rate>|#<tags>|T<timestamp>|card:<cardinality>
+type DogStatsDEncoder struct{}
+
+func (DogStatsDEncoder) Encode(m Metric) []byte {
+	var b bytes.Buffer
+	// format has already checked the type.
+	suffix, _ := m.Type.suffix()
+	fmt.Fprintf(&b, "%s:%s|%s", m.Name, m.Value, suffix)
+	if m.Rate < 1 {
+		fmt.Fprintf(&b, "|@%f", m.Rate)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Fprintf(&b, "|#%s", strings.Join(m.Tags, ","))
+	}
+	if !m.Timestamp.IsZero() {
+		fmt.Fprintf(&b, "|T%d", m.Timestamp.Unix())
+	}
+	if m.Cardinality != "" {
+		fmt.Fprintf(&b, "|card:%s", m.Cardinality)
+	}
+	return b.Bytes()
+}
+
+// InfluxEncoder encodes metrics in the InfluxDB line protocol, for sending
+// to an InfluxDB-compatible UDP listener. Tags of the form key:value become
+// key=value and other tags become tag=true. The metric type is sent as the
+// metric_type tag, the value as the value field, numeric when it parses as a
+// number, and the sample rate, when below 1, as the sample_rate field.
+// Cardinality is not encoded.
+type InfluxEncoder struct{}
+
+var (
+	influxNameEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper  = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+	influxStrEscaper  = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+func (InfluxEncoder) Encode(m Metric) []byte {
+	var b bytes.Buffer
+	b.WriteString(influxNameEscaper.Replace(m.Name))
+	fmt.Fprintf(&b, ",metric_type=%s", m.Type)
+	for _, t := range m.Tags {
+		key, value, ok := strings.Cut(t, ":")
+		if !ok {
+			value = "true"
+		}
+		fmt.Fprintf(&b, ",%s=%s", influxTagEscaper.Replace(key), influxTagEscaper.Replace(value))
+	}
+	if _, err := strconv.ParseFloat(m.Value, 64); err == nil {
+		fmt.Fprintf(&b, " value=%s", m.Value)
+	} else {
+		fmt.Fprintf(&b, ` value="%s"`, influxStrEscaper.Replace(m.Value))
+	}
+	if m.Rate < 1 {
+		fmt.Fprintf(&b, ",sample_rate=%s", strconv.FormatFloat(m.Rate, 'f', -1, 64))
+	}
+	if !m.Timestamp.IsZero() {
+		fmt.Fprintf(&b, " %d", m.Timestamp.UnixNano())
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"testing"
+	"time"
+)
+
+var influxTests = []struct {
+	Metric   Metric
+	Expected string
+}{
+	{
+		Metric{Name: "test.gauge", Type: Gauge, Value: "1.5", Rate: 1},
+		"test.gauge,metric_type=gauge value=1.5",
+	},
+	{
+		Metric{Name: "test.count", Type: Count, Value: "3", Rate: 0.5, Tags: []string{"env:prod", "canary"}},
+		"test.count,metric_type=count,env=prod,canary=true value=3,sample_rate=0.5",
+	},
+	{
+		Metric{Name: "test set", Type: Set, Value: `a "b"`, Rate: 1, Tags: []string{"k=v:a,b c"}},
+		`test\ set,metric_type=set,k\=v=a\,b\ c value="a \"b\""`,
+	},
+	{
+		Metric{Name: "test.gauge", Type: Gauge, Value: "1", Rate: 1, Timestamp: time.Unix(1411080960, 0)},
+		"test.gauge,metric_type=gauge value=1 1411080960000000000",
+	},
+}
+
+func TestInfluxEncoder(t *testing.T) {
+	for _, tt := range influxTests {
+		if actual := string((InfluxEncoder{}).Encode(tt.Metric)); actual != tt.Expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.Expected, actual)
+		}
+	}
+}
+
+func TestClientEncoder(t *testing.T) {
+	r := &ring{buf: make([]string, 1)}
+	c, err := newConnClient(r, Options{Encoder: InfluxEncoder{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetNamespace("flubber.")
+	c.SetTags([]string{"env:prod"})
+	if err := c.Count("test.count", 1, []string{"role:web"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	expected := "flubber.test.count,metric_type=count,env=prod,role=web value=1"
+	if sent := r.contents(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %s. Actual: %v", expected, sent)
+	}
+}
+
+var dogStatsDTests = []struct {
+	Metric   Metric
+	Expected string
+}{
+	{
+		Metric{Name: "test.count", Type: Count, Value: "1", Rate: 1},
+		"test.count:1|c",
+	},
+	{
+		Metric{Name: "test.count", Type: Count, Value: "1", Rate: 0.5},
+		"test.count:1|c|@0.500000",
+	},
+	{
+		Metric{Name: "test.count", Type: Count, Value: "1", Rate: 1, Tags: []string{"a", "b:c"}},
+		"test.count:1|c|#a,b:c",
+	},
+	{
+		Metric{Name: "test.count", Type: Count, Value: "1", Rate: 1, Timestamp: time.Unix(1411080960, 0)},
+		"test.count:1|c|T1411080960",
+	},
+	{
+		Metric{Name: "test.count", Type: Count, Value: "1", Rate: 1, Cardinality: CardinalityLow},
+		"test.count:1|c|card:low",
+	},
+	{
+		Metric{Name: "test.gauge", Type: Gauge, Value: "2.5", Rate: 0.5, Tags: []string{"a"}},
+		"test.gauge:2.5|g|@0.500000|#a",
+	},
+	{
+		Metric{Name: "test.gauge", Type: Gauge, Value: "2.5", Rate: 1, Tags: []string{"a"}, Timestamp: time.Unix(1411080960, 0)},
+		"test.gauge:2.5|g|#a|T1411080960",
+	},
+	{
+		Metric{Name: "test.timer", Type: Timing, Value: "3", Rate: 0.5, Timestamp: time.Unix(1411080960, 0), Cardinality: CardinalityHigh},
+		"test.timer:3|ms|@0.500000|T1411080960|card:high",
+	},
+	{
+		Metric{Name: "test.set", Type: Set, Value: "x", Rate: 0.25, Tags: []string{"a", "b"}, Timestamp: time.Unix(1411080960, 0), Cardinality: CardinalityOrchestrator},
+		"test.set:x|s|@0.250000|#a,b|T1411080960|card:orchestrator",
+	},
+	{
+		Metric{Name: "test.histogram", Type: Histogram, Value: "1:2", Rate: 1, Tags: []string{"a"}, Cardinality: CardinalityNone},
+		"test.histogram:1:2|h|#a|card:none",
+	},
+	{
+		Metric{Name: "test.distribution", Type: Distribution, Value: "4", Rate: 0.5, Tags: []string{"a"}, Timestamp: time.Unix(1411080960, 0)},
+		"test.distribution:4|d|@0.500000|#a|T1411080960",
+	},
+}
+
+func TestDogStatsDEncoderFieldOrder(t *testing.T) {
+	for _, tt := range dogStatsDTests {
+		if actual := string((DogStatsDEncoder{}).Encode(tt.Metric)); actual != tt.Expected {
+			t.Errorf("Expected: %s. Actual: %s", tt.Expected, actual)
+		}
+	}
+}
+
+func TestEventFieldOrder(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{EventAggregationKey: "deploys"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTags([]string{"env:prod"})
+	c.Error("title", "text", []string{"tagA"},
+		WithSourceTypeName("ci"), WithDateHappened(time.Unix(1411080960, 0)),
+		WithPriority(Low), WithHost("web1"))
+	c.Info("title", "text", nil, WithHost("web1"))
+	c.Event("title", "text", &EventOpts{AlertType: Success, Priority: Normal, AggregationKey: "release"})
+
+	expected := []string{
+		"_e{5,4}:title|text|t:error|s:ci|d:1411080960|p:low|h:web1|k:deploys|#env:prod,tagA",
+		"_e{5,4}:title|text|t:info|h:web1|k:deploys|#env:prod",
+		"_e{5,4}:title|text|t:success|p:normal|k:release|#env:prod",
+	}
+	sent := r.contents()
+	if len(sent) != len(expected) {
+		t.Fatalf("Expected %d events, got %q", len(expected), sent)
+	}
+	for i, message := range sent {
+		if message != expected[i] {
+			t.Errorf("Expected: %s. Actual: %s", expected[i], message)
+		}
+	}
+}
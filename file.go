@@ -0,0 +1,25 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import "os"
+
+// NewFile returns a Client that appends metrics and events to the file at
+// path, one DogStatsD line each, for later ingestion where no agent is
+// reachable. The file is created if needed and is never truncated or
+// rotated, so it grows until removed. Since every write appends, it can be
+// rotated externally by copying and then truncating it.
+func NewFile(path string) (Client, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newConnClient(f, Options{})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Terminate each line with a newline, as on stream networks.
+	c.stream = true
+	return c, nil
+}
@@ -0,0 +1,35 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.log")
+	for i := 0; i < 2; i++ {
+		client, err := NewFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Count("test.count", int64(i), []string{"tagA"}, 1.0); err != nil {
+			t.Fatal(err)
+		}
+		client.Info("title", "text", nil)
+		if err := client.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:0|c|#tagA\n_e{5,4}:title|text|t:info\ntest.count:1|c|#tagA\n_e{5,4}:title|text|t:info\n"
+	if string(data) != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, data)
+	}
+}
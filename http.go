@@ -0,0 +1,129 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RecordHTTP sends the count http.requests and the timing
+// http.request.duration, in milliseconds, for one HTTP request in a single
+// packet. Both are tagged with method:<method>, path:<path> and
+// status:<status> followed by extraTags. Every distinct path creates new tag
+// values, so pass a route pattern such as "/users/:id" rather than the raw
+// request path to keep the number of series bounded.
+func (c *client) RecordHTTP(method, path string, status int, duration time.Duration, extraTags []string) error {
+	tags := make([]string, 0, 3+len(extraTags))
+	tags = append(tags, "method:"+method, "path:"+path, "status:"+strconv.Itoa(status))
+	tags = append(tags, extraTags...)
+	return c.recordHTTP(tags, duration)
+}
+
+func (c *client) recordHTTP(tags []string, duration time.Duration) error {
+	return c.submitSampled([]batchMetric{
+		{mtype: Count, name: "http.requests", value: "1", tags: tags},
+		{mtype: Timing, name: "http.request.duration", value: c.formatFloat(durationMs(duration)), tags: tags},
+	}, 1)
+}
+
+// MiddlewareOption configures the handlers returned by Middleware.
+type MiddlewareOption func(*middleware)
+
+// MiddlewareTags selects which of the method:, status: and route: tags are sent.
+func MiddlewareTags(method, status, route bool) MiddlewareOption {
+	return func(m *middleware) {
+		m.method, m.status, m.route = method, status, route
+	}
+}
+
+// MiddlewareRoute sets the function returning the route: tag of a request.
+// It defaults to the request path, which creates a new tag value for every
+// distinct path; return a route pattern instead to bound the number of series.
+func MiddlewareRoute(f func(*http.Request) string) MiddlewareOption {
+	return func(m *middleware) {
+		m.routeFunc = f
+	}
+}
+
+type middleware struct {
+	c                     Client
+	method, status, route bool
+	routeFunc             func(*http.Request) string
+}
+
+// Middleware returns a function wrapping HTTP handlers so that every request
+// they serve sends the metrics of RecordHTTP, tagged by default with
+// method:, status: and route:. If c is nil handlers are returned unwrapped.
+func Middleware(c Client, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{
+		c:         c,
+		method:    true,
+		status:    true,
+		route:     true,
+		routeFunc: func(r *http.Request) string { return r.URL.Path },
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return func(next http.Handler) http.Handler {
+		if c == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r)
+			m.record(r, sw.status, time.Since(start))
+		})
+	}
+}
+
+func (m *middleware) record(r *http.Request, status int, duration time.Duration) {
+	var tags []string
+	if m.method {
+		tags = append(tags, "method:"+r.Method)
+	}
+	if m.status {
+		tags = append(tags, "status:"+strconv.Itoa(status))
+	}
+	if m.route {
+		tags = append(tags, "route:"+m.routeFunc(r))
+	}
+	// The response has been written, so there is nobody to report errors to.
+	if hr, ok := m.c.(interface {
+		recordHTTP([]string, time.Duration) error
+	}); ok {
+		hr.recordHTTP(tags, duration)
+		return
+	}
+	m.c.Count("http.requests", 1, tags, 1)
+	m.c.Timing("http.request.duration", duration, tags, 1)
+}
+
+// statusWriter records the status code written to a ResponseWriter. It
+// implements http.Flusher for streaming handlers, and Unwrap gives
+// http.ResponseController access to the other optional interfaces of the
+// wrapped writer, such as http.Hijacker.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the wrapped writer if it supports it.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
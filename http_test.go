@@ -0,0 +1,104 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordHTTP(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+
+	if err := client.RecordHTTP("GET", "/users/:id", 200, 1500*time.Microsecond, []string{"tagA"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "http.requests:1|c|#method:GET,path:/users/:id,status:200,tagA\n" +
+		"http.request.duration:1.500000|ms|#method:GET,path:/users/:id,status:200,tagA"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	r := NewRecorder(1)
+	Middleware(r)(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/brew", nil))
+	sent := r.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("Expected one packet, got %v", sent)
+	}
+	lines := strings.Split(sent[0], "\n")
+	if len(lines) != 2 ||
+		lines[0] != "http.requests:1|c|#method:POST,status:418,route:/brew" ||
+		!strings.HasPrefix(lines[1], "http.request.duration:") ||
+		!strings.HasSuffix(lines[1], "|ms|#method:POST,status:418,route:/brew") {
+		t.Errorf("Unexpected packet: %q", sent[0])
+	}
+
+	r = NewRecorder(1)
+	wrapped := Middleware(r,
+		MiddlewareTags(false, true, true),
+		MiddlewareRoute(func(*http.Request) string { return "/brew/:pot" }),
+	)(handler)
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/brew/1", nil))
+	if sent := r.Sent(); len(sent) != 1 || !strings.HasPrefix(sent[0], "http.requests:1|c|#status:418,route:/brew/:pot\n") {
+		t.Errorf("Unexpected packet: %v", sent)
+	}
+}
+
+// hijackRecorder is a ResponseRecorder supporting http.Hijacker.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestMiddlewareOptionalInterfaces(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("Expected the ResponseWriter to implement http.Flusher")
+		}
+		w.Write([]byte("event"))
+		f.Flush()
+		if _, _, err := http.NewResponseController(w).Hijack(); err != nil {
+			t.Errorf("Expected Hijack to reach the wrapped writer, got %v", err)
+		}
+	})
+
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	Middleware(NewRecorder(1))(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	if !rec.Flushed {
+		t.Error("Expected the wrapped writer to be flushed")
+	}
+	if !rec.hijacked {
+		t.Error("Expected the wrapped writer to be hijacked")
+	}
+}
+
+func TestMiddlewareNilClient(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	wrapped := Middleware(nil)(handler)
+	if reflect.ValueOf(wrapped).Pointer() != reflect.ValueOf(handler).Pointer() {
+		t.Error("Expected handler to be returned unwrapped")
+	}
+	wrapped.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxLogTagBytes is the longest tag value EventFromLog sends; Datadog
+// truncates tags at 200 characters.
+const maxLogTagBytes = 200
+
+// logTagReplacer replaces the characters that would split a tag.
+var logTagReplacer = strings.NewReplacer(",", "_", "|", "_", "\n", "_")
+
+// EventFromLog posts an event for a structured log entry. The level sets the
+// alert type: "error", "fatal", "panic" and "critical" give an error,
+// "warn" and "warning" a warning, anything else an info event. The title is
+// the "msg" field, or else the "message" field, or "log <level>" if there is
+// neither; when both are set, "message" is sent with the other fields. The
+// fields named in Options.LogTagFields become tags field:value, cut to 200
+// bytes, and the other fields are sent as the text, "key=value" separated by
+// spaces in key order, shortened and ended with "..." if the event would
+// exceed the 8KB limit.
+func (c *client) EventFromLog(level string, fields map[string]interface{}) error {
+	alertType := Info
+	switch strings.ToLower(level) {
+	case "error", "fatal", "panic", "critical":
+		alertType = Error
+	case "warn", "warning":
+		alertType = Warning
+	}
+
+	title, titleKey := "log "+level, ""
+	for _, key := range []string{"msg", "message"} {
+		if value, ok := fields[key]; ok {
+			title, titleKey = fmt.Sprint(value), key
+			break
+		}
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != titleKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var tags, text []string
+	for _, key := range keys {
+		value := fmt.Sprint(fields[key])
+		if c.logTagFields[key] {
+			tags = append(tags, key+":"+truncate(logTagReplacer.Replace(value), maxLogTagBytes))
+		} else {
+			text = append(text, key+"="+value)
+		}
+	}
+	return c.event(title, strings.Join(text, " "), newDefaultEventOpts(alertType, tags, c.defaultEventSource(), c.eventHost, nil), true)
+}
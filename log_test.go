@@ -0,0 +1,40 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventFromLog(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{LogTagFields: []string{"service", "path"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.EventFromLog("ERROR", map[string]interface{}{
+		"msg":     "query failed",
+		"service": "billing",
+		"path":    "/a,b|c",
+		"attempt": 3,
+		"err":     "timeout",
+	})
+	c.EventFromLog("warn", map[string]interface{}{"message": "slow", "ms": 1200})
+	c.EventFromLog("debug", nil)
+	c.EventFromLog("info", map[string]interface{}{"message": "retrying", "msg": "request failed"})
+	c.EventFromLog("info", map[string]interface{}{"service": strings.Repeat("x", 300)})
+
+	expected := []string{
+		"_e{12,21}:query failed|attempt=3 err=timeout|t:error|#path:/a_b_c,service:billing",
+		"_e{4,7}:slow|ms=1200|t:warning",
+		"_e{9,0}:log debug||t:info",
+		"_e{14,16}:request failed|message=retrying|t:info",
+		"_e{8,0}:log info||t:info|#service:" + strings.Repeat("x", 200),
+	}
+	if sent := r.contents(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
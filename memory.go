@@ -0,0 +1,63 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import "sync"
+
+// Recorder is a Client that keeps the payloads it would send to the agent in
+// memory, so tests can assert on the exact wire output including sampling
+// and tags.
+type Recorder struct {
+	*client
+	ring *ring
+}
+
+// NewRecorder returns a Recorder keeping the last size payloads sent.
+func NewRecorder(size int) *Recorder {
+	r := &ring{buf: make([]string, size)}
+	// Creating a client with no options cannot fail.
+	c, _ := newConnClient(r, Options{})
+	return &Recorder{client: c, ring: r}
+}
+
+// Sent returns the recorded payloads, oldest first.
+func (r *Recorder) Sent() []string {
+	return r.ring.contents()
+}
+
+// ring is a bounded buffer keeping the most recently written payloads.
+type ring struct {
+	mu    sync.Mutex
+	buf   []string
+	start int
+	n     int
+}
+
+func (r *ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buf) == 0 {
+		return len(p), nil
+	}
+	r.buf[(r.start+r.n)%len(r.buf)] = string(p)
+	if r.n < len(r.buf) {
+		r.n++
+	} else {
+		r.start = (r.start + 1) % len(r.buf)
+	}
+	return len(p), nil
+}
+
+func (r *ring) Close() error {
+	return nil
+}
+
+func (r *ring) contents() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent := make([]string, r.n)
+	for i := range sent {
+		sent[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return sent
+}
@@ -0,0 +1,26 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(2)
+	r.SetTags([]string{"env:test"})
+	if sent := r.Sent(); len(sent) != 0 {
+		t.Errorf("Expected nothing sent, got %v", sent)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if err := r.Count("test.count", i, nil, 1.0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"test.count:2|c|#env:test", "test.count:3|c|#env:test"}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %v. Actual: %v", expected, sent)
+	}
+}
@@ -0,0 +1,7 @@
+// Copyright 2013 Ooyala, Inc.
+
+//go:build !race
+
+package dogstatsd
+
+const raceEnabled = false
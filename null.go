@@ -0,0 +1,32 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import "sync/atomic"
+
+// disabled turns off every client, see SetEnabled.
+var disabled atomic.Bool
+
+// SetEnabled turns sending metrics and events on or off for every client in
+// the process, e.g. to switch instrumentation off in performance critical
+// deployments. While off, methods return nil at once without sending
+// anything; Gauge, Count, Histogram, Timer, Timing, Set and the other
+// single metric methods do so without allocating. Sending is on by default.
+func SetEnabled(on bool) {
+	disabled.Store(!on)
+}
+
+// NewNull returns a Client that discards everything sent to it as cheaply as
+// a client disabled by SetEnabled, for use where metrics are not wanted.
+func NewNull() Client {
+	// Creating a client with no options cannot fail, and a ring with no
+	// room discards every payload.
+	c, _ := newConnClient(&ring{}, Options{})
+	c.null = true
+	return c
+}
+
+// off reports whether c sends nothing.
+func (c *client) off() bool {
+	return c.null || disabled.Load()
+}
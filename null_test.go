@@ -0,0 +1,47 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewNull(t *testing.T) {
+	c := NewNull()
+	defer c.Close()
+	tags := []string{"tagA"}
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Gauge("test.gauge", 1, tags, 1)
+		c.Count("test.count", 1, tags, 1)
+		c.Timing("test.timer", time.Second, tags, 1)
+		c.Set("test.set", "a", tags, 1)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations, got %v", allocs)
+	}
+	if err := c.Info("title", "text", tags); err != nil {
+		t.Errorf("Expected events to be discarded without error, got %v", err)
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	r := NewRecorder(10)
+	SetEnabled(false)
+	r.Count("test.count", 1, nil, 1)
+	r.Info("title", "text", nil)
+	r.HistogramWithCount("test.histogram", 1, nil, 1)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Gauge("test.gauge", 1, nil, 1)
+	})
+	SetEnabled(true)
+	if allocs != 0 {
+		t.Errorf("Expected no allocations while disabled, got %v", allocs)
+	}
+	r.Count("test.count", 2, nil, 1)
+
+	expected := "test.count:2|c"
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEventLine is returned by Parse for a DogStatsD event.
+	ErrEventLine = errors.New("Line is an event, not a metric")
+	// ErrServiceCheckLine is returned by Parse for a DogStatsD service check.
+	ErrServiceCheckLine = errors.New("Line is a service check, not a metric")
+)
+
+// metricTypes lists every MetricType, to look them up by type field.
+var metricTypes = []MetricType{Gauge, Count, Histogram, Distribution, Timing, Set}
+
+// Parse decodes a DogStatsD metric line, such as one produced by
+// DogStatsDEncoder. Packed values are returned as sent, e.g. "1:2:3", and
+// Rate is 1 when the line has no sample rate. Unknown fields are ignored.
+// Events and service checks are rejected with ErrEventLine and
+// ErrServiceCheckLine.
+func Parse(line string) (Metric, error) {
+	switch {
+	case strings.HasPrefix(line, "_e{"):
+		return Metric{}, ErrEventLine
+	case strings.HasPrefix(line, "_sc|"):
+		return Metric{}, ErrServiceCheckLine
+	}
+
+	m := Metric{Rate: 1}
+	name, rest, ok := strings.Cut(line, ":")
+	fields := strings.Split(rest, "|")
+	if !ok || name == "" || len(fields) < 2 || fields[0] == "" {
+		return Metric{}, fmt.Errorf("Invalid metric line '%s'", line)
+	}
+	m.Name, m.Value = name, fields[0]
+	for _, t := range metricTypes {
+		if suffix, _ := t.suffix(); suffix == fields[1] {
+			m.Type = t
+		}
+	}
+	if m.Type == "" {
+		return Metric{}, fmt.Errorf("Unknown metric type '%s' in line '%s'", fields[1], line)
+	}
+
+	for _, field := range fields[2:] {
+		var err error
+		switch {
+		case strings.HasPrefix(field, "@"):
+			m.Rate, err = strconv.ParseFloat(field[1:], 64)
+		case strings.HasPrefix(field, "#"):
+			m.Tags = strings.Split(field[1:], ",")
+		case strings.HasPrefix(field, "T"):
+			var ts int64
+			ts, err = strconv.ParseInt(field[1:], 10, 64)
+			m.Timestamp = time.Unix(ts, 0)
+		case strings.HasPrefix(field, "card:"):
+			m.Cardinality = Cardinality(field[len("card:"):])
+		}
+		if err != nil {
+			return Metric{}, fmt.Errorf("Invalid field '%s' in line '%s'", field, line)
+		}
+	}
+	return m, nil
+}
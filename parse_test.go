@@ -0,0 +1,74 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var parseTests = []struct {
+	Line     string
+	Expected Metric
+}{
+	{"test.gauge:1.000000|g", Metric{Name: "test.gauge", Type: Gauge, Value: "1.000000", Rate: 1}},
+	{"test.set:a:b|s", Metric{Name: "test.set", Type: Set, Value: "a:b", Rate: 1}},
+	{
+		"test.count:1|c|@0.500000|#tagA,env:prod|T1411080960|card:low|c:abc",
+		Metric{
+			Name:        "test.count",
+			Type:        Count,
+			Value:       "1",
+			Rate:        0.5,
+			Tags:        []string{"tagA", "env:prod"},
+			Timestamp:   time.Unix(1411080960, 0),
+			Cardinality: CardinalityLow,
+		},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range parseTests {
+		m, err := Parse(tt.Line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, tt.Expected) {
+			t.Errorf("Expected: %+v. Actual: %+v", tt.Expected, m)
+		}
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	r := NewRecorder(1)
+	r.SetNamespace("flubber.")
+	c := r.WithTags("env:prod").WithTimestamp(time.Now()).WithCardinality(CardinalityHigh)
+	if err := c.Histogram("test.histogram", 2.3, []string{"tagA"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	line := r.Sent()[0]
+	m, err := Parse(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded := string((DogStatsDEncoder{}).Encode(m)); encoded != line {
+		t.Errorf("Expected: %s. Actual: %s", line, encoded)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for line, expected := range map[string]string{
+		"_e{5,4}:title|text":        ErrEventLine.Error(),
+		"_sc|check|0":               ErrServiceCheckLine.Error(),
+		"test.gauge":                "Invalid metric line 'test.gauge'",
+		"test.gauge:1":              "Invalid metric line 'test.gauge:1'",
+		"test.gauge:1|x":            "Unknown metric type 'x' in line 'test.gauge:1|x'",
+		"test.gauge:1|g|@half":      "Invalid field '@half' in line 'test.gauge:1|g|@half'",
+		"test.gauge:1|g|Tyesterday": "Invalid field 'Tyesterday' in line 'test.gauge:1|g|Tyesterday'",
+	} {
+		if _, err := Parse(line); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %s, got %v", expected, line, err)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultFlushInterval is used unless Options.FlushInterval is set.
+const DefaultFlushInterval = 10 * time.Second
+
+// DefaultRateWindow is used unless Options.RateWindow is set.
+const DefaultRateWindow = time.Minute
+
+// periodic calls a function on an interval from a background goroutine.
+type periodic struct {
+	mu     sync.Mutex
+	stop   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// start runs f every interval until close is called. Calls after the first
+// one, or after close, have no effect.
+func (p *periodic) start(interval time.Duration, f func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stop != nil || p.closed {
+		return
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-stop:
+				return
+			}
+		}
+	}(p.stop, p.done)
+}
+
+// close stops the goroutine, if running, and waits for it to exit. The
+// goroutine can't be started again.
+func (p *periodic) close() {
+	p.mu.Lock()
+	stop, done := p.stop, p.done
+	p.stop, p.done = nil, nil
+	p.closed = true
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// registeredGauge is a gauge whose value is read from f on every flush.
+type registeredGauge struct {
+	c    *client
+	key  string
+	name string
+	tags []string
+	f    func() float64
+	// Set for the gauges of CountRate, whose f resets the count
+	rate bool
+	// How long the gauge may keep the same value before it is unregistered,
+	// zero for no limit
+	ttl time.Duration
+	// Value of the last flush and when it changed, used with ttl
+	last    float64
+	updated time.Time
+}
+
+// gaugeSet holds the registered gauges of a client, and the counts behind
+// the gauges of CountRate and RecordRate, keyed by name and tags.
+type gaugeSet struct {
+	mu      sync.Mutex
+	gauges  map[string]*registeredGauge
+	rates   map[string]*Counter
+	windows map[string]*rateWindow
+}
+
+func newGaugeSet() *gaugeSet {
+	return &gaugeSet{
+		gauges:  make(map[string]*registeredGauge),
+		rates:   make(map[string]*Counter),
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// RegisterGauge sends the value returned by f as a gauge on every flush
+// interval until the client is closed. Registering the same name and tags
+// again replaces f. Registering on a closed or null client has no effect.
+func (c *client) RegisterGauge(name string, tags []string, f func() float64) {
+	if c.closed.Load() || c.null {
+		return
+	}
+	c.gauges.mu.Lock()
+	key := c.seriesKey(name, tags)
+	c.gauges.gauges[key] = &registeredGauge{c: c, key: key, name: name, tags: tags, f: f}
+	c.gauges.mu.Unlock()
+	c.periodic.start(c.flushInterval, c.flush)
+}
+
+// RegisterGaugeWithTTL is like RegisterGauge but unregisters the gauge once
+// f has returned the same value for longer than ttl, for entities such as
+// connections or tenants that may disappear without anyone unregistering
+// their gauges. Expired gauges are removed by the flush goroutine, so a
+// gauge may be sent for up to a flush interval past its TTL. Registering the
+// same name and tags again restarts the TTL.
+//
+// Once unregistered the gauge is no longer sent, and the agent stops
+// reporting it after the current flush interval. Datadog keeps no state for
+// gauges, but graphs may draw the last value over the gap left behind;
+// unlike RegisterTTLGauge, which keeps reporting 0, the series ends.
+func (c *client) RegisterGaugeWithTTL(name string, tags []string, f func() float64, ttl time.Duration) {
+	if c.closed.Load() || c.null {
+		return
+	}
+	c.gauges.mu.Lock()
+	key := c.seriesKey(name, tags)
+	c.gauges.gauges[key] = &registeredGauge{c: c, key: key, name: name, tags: tags, f: f, ttl: ttl, updated: c.now()}
+	c.gauges.mu.Unlock()
+	c.periodic.start(c.flushInterval, c.flush)
+}
+
+// RegisteredGauges returns the sorted names of the gauges sent on every flush
+// interval, those of RegisterGauge and its variants and the <name>.rate
+// gauges of CountRate, with the namespace of the client that registered
+// them, as sent. A name registered with several tag sets is listed once.
+func (c *client) RegisteredGauges() []string {
+	c.gauges.mu.Lock()
+	seen := make(map[string]bool, len(c.gauges.gauges))
+	names := make([]string, 0, len(c.gauges.gauges))
+	for _, g := range c.gauges.gauges {
+		if name := g.c.metricName(g.name); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	c.gauges.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// TTLGauge is a gauge reported on every flush interval with the last value
+// set, or 0 once no value has been set for longer than its TTL. It is safe
+// for concurrent use.
+type TTLGauge struct {
+	mu      sync.Mutex
+	value   float64
+	updated time.Time
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// Set sets the value reported until the TTL expires.
+func (g *TTLGauge) Set(value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = value
+	g.updated = g.now()
+}
+
+func (g *TTLGauge) current() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.updated.IsZero() || g.now().Sub(g.updated) > g.ttl {
+		return 0
+	}
+	return g.value
+}
+
+// RegisterTTLGauge registers a TTLGauge for name and tags, for values such
+// as active uploads that should fall back to zero if whatever updates them
+// stops, e.g. after a crash. Datadog does not clear a gauge on its own:
+// graphs keep showing the last value sent, so a gauge that is no longer
+// updated would otherwise look stuck at it.
+func (c *client) RegisterTTLGauge(name string, tags []string, ttl time.Duration) *TTLGauge {
+	g := &TTLGauge{ttl: ttl, now: c.now}
+	c.RegisterGauge(name, tags, g.current)
+	return g
+}
+
+// UnregisterGauge stops reporting the gauge for name and tags, whether it was
+// registered with RegisterGauge, CountRate or RecordRate, which then start
+// over on their next call, or sent by GaugeOnChange, which then sends its
+// next value even if unchanged. A CountRate gauge is unregistered by its
+// <name>.rate name. If sendZero is set a final value of 0 is
+// sent. DogStatsD has no way to delete a gauge: the agent reports a gauge for
+// every flush interval in which it received a value and nothing afterwards,
+// but Datadog graphs may carry the last value over the gap, so sending a
+// final 0 makes it clear that the tracked entity went away.
+func (c *client) UnregisterGauge(name string, tags []string, sendZero bool) error {
+	key := c.seriesKey(name, tags)
+	c.gauges.mu.Lock()
+	if g, ok := c.gauges.gauges[key]; ok && g.rate {
+		delete(c.gauges.rates, c.seriesKey(strings.TrimSuffix(name, ".rate"), tags))
+	}
+	delete(c.gauges.gauges, key)
+	delete(c.gauges.windows, key)
+	c.gauges.mu.Unlock()
+	c.lastGauges.mu.Lock()
+	delete(c.lastGauges.values, key)
+	c.lastGauges.mu.Unlock()
+	if sendZero {
+		return c.Gauge(name, 0, tags, 1)
+	}
+	return nil
+}
+
+// RegisterUtilization sends the percentage of capacity in use, as returned
+// by f, as a gauge on every flush interval, e.g. for a buffered channel
+// with func() (int, int) { return len(ch), cap(ch) }. A capacity of zero or
+// less reports 0 rather than dividing by zero.
+func (c *client) RegisterUtilization(name string, tags []string, f func() (used, capacity int)) {
+	c.RegisterGauge(name, tags, func() float64 {
+		used, capacity := f()
+		if capacity <= 0 {
+			return 0
+		}
+		return 100 * float64(used) / float64(capacity)
+	})
+}
+
+// CountRate adds value to a count that is sent on every flush interval as
+// the gauge <name>.rate: the count over the interval divided by its length
+// in seconds. The count then restarts from zero, so intervals without calls
+// report a rate of zero until the client is closed.
+func (c *client) CountRate(name string, value int64, tags []string) {
+	if c.closed.Load() || c.null {
+		return
+	}
+	key := c.seriesKey(name, tags)
+	c.gauges.mu.Lock()
+	counter, ok := c.gauges.rates[key]
+	if !ok {
+		counter = &Counter{}
+		c.gauges.rates[key] = counter
+		interval := c.flushInterval.Seconds()
+		rateKey := c.seriesKey(name+".rate", tags)
+		c.gauges.gauges[rateKey] = &registeredGauge{
+			c:    c,
+			key:  rateKey,
+			name: name + ".rate",
+			tags: tags,
+			f: func() float64 {
+				return float64(atomic.SwapInt64(&counter.value, 0)) / interval
+			},
+			rate: true,
+		}
+	}
+	c.gauges.mu.Unlock()
+	counter.Add(value)
+	c.periodic.start(c.flushInterval, c.flush)
+}
+
+// rateWindow counts events in one-second buckets over a sliding window.
+type rateWindow struct {
+	mu sync.Mutex
+	// Event counts and the second each bucket holds, indexed by the second
+	// modulo the window length
+	counts []int64
+	secs   []int64
+}
+
+func newRateWindow(window time.Duration) *rateWindow {
+	n := int((window + time.Second - 1) / time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return &rateWindow{counts: make([]int64, n), secs: make([]int64, n)}
+}
+
+// record counts an event at now.
+func (w *rateWindow) record(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sec := now.Unix()
+	i := int(sec % int64(len(w.counts)))
+	if w.secs[i] != sec {
+		w.secs[i], w.counts[i] = sec, 0
+	}
+	w.counts[i]++
+}
+
+// rate returns the events per second over the window ending at now.
+func (w *rateWindow) rate(now time.Time) float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := int64(len(w.counts))
+	sec := now.Unix()
+	var total int64
+	for i, s := range w.secs {
+		if s > sec-n && s <= sec {
+			total += w.counts[i]
+		}
+	}
+	return float64(total) / float64(n)
+}
+
+// RecordRate counts an event for a rate sent as the gauge name, with tags,
+// on every flush interval until the client is closed: the events per second
+// over a sliding window ending at the flush, Options.RateWindow long. Unlike
+// CountRate, whose rate covers the flush interval and restarts at every
+// flush, the window may be longer than the flush interval, e.g. a one-minute
+// rate reported every ten seconds, smoothing out bursts. Events are counted
+// in one-second buckets, so the window is rounded up to whole seconds and
+// the rate moves in steps of a second.
+func (c *client) RecordRate(name string, tags []string) {
+	if c.closed.Load() || c.null {
+		return
+	}
+	key := c.seriesKey(name, tags)
+	c.gauges.mu.Lock()
+	window, ok := c.gauges.windows[key]
+	if !ok {
+		window = newRateWindow(c.rateWindow)
+		c.gauges.windows[key] = window
+		c.gauges.gauges[key] = &registeredGauge{
+			c:    c,
+			key:  key,
+			name: name,
+			tags: tags,
+			f:    func() float64 { return window.rate(c.now()) },
+		}
+	}
+	c.gauges.mu.Unlock()
+	window.record(c.now())
+	c.periodic.start(c.flushInterval, c.flush)
+}
+
+// flush sends the metrics that are reported on the flush interval.
+func (c *client) flush() {
+	c.gauges.mu.Lock()
+	gauges := make([]*registeredGauge, 0, len(c.gauges.gauges))
+	for _, g := range c.gauges.gauges {
+		gauges = append(gauges, g)
+	}
+	c.gauges.mu.Unlock()
+	for _, g := range gauges {
+		value := g.f()
+		if g.ttl > 0 {
+			now := c.now()
+			if value != g.last {
+				g.last, g.updated = value, now
+			} else if now.Sub(g.updated) > g.ttl {
+				c.gauges.mu.Lock()
+				if c.gauges.gauges[g.key] == g {
+					delete(c.gauges.gauges, g.key)
+				}
+				c.gauges.mu.Unlock()
+				continue
+			}
+		}
+		// Nobody is waiting on a periodic flush to report errors to.
+		g.c.Gauge(g.name, value, g.tags, 1)
+	}
+}
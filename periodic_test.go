@@ -0,0 +1,303 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRegisterGauge(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	c, err := NewWithOptions(addr, Options{FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.RegisterGauge("queue.depth", []string{"tagA"}, func() float64 { return 42 })
+	for i := 0; i < 2; i++ {
+		expected := "queue.depth:42.000000|g|#tagA"
+		if message := serverRead(t, server); message != expected {
+			t.Errorf("Expected: %s. Actual: %s", expected, message)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.(*client).gauges.gauges); n != 0 {
+		t.Errorf("Expected gauges to be unregistered on Close, got %d", n)
+	}
+}
+
+func TestRegisterAfterClose(t *testing.T) {
+	r := NewRecorder(10)
+	derived := r.WithNamespace("derived")
+	r.Close()
+
+	for _, c := range []Client{r, derived} {
+		c.RegisterGauge("worker.busy", nil, func() float64 { return 1 })
+		c.RegisterGaugeWithTTL("tenant.users", nil, func() float64 { return 1 }, time.Minute)
+		c.RegisterUtilization("pool.utilization", nil, func() (int, int) { return 1, 2 })
+		c.RegisterTTLGauge("uploads.active", nil, time.Minute)
+		c.CountRate("requests", 1, nil)
+		c.RecordRate("requests.per_second", nil)
+		if err := c.StartRuntimeMetrics(time.Millisecond, nil); err != ErrClientClosed {
+			t.Errorf("Expected ErrClientClosed, got %v", err)
+		}
+	}
+	if names := r.RegisteredGauges(); len(names) != 0 {
+		t.Errorf("Expected no gauges registered after Close, got %q", names)
+	}
+	if r.periodic.stop != nil || r.runtimeMetrics.stop != nil {
+		t.Error("Expected no goroutine started after Close")
+	}
+
+	// The goroutine isn't restarted even if the closed check is passed.
+	r.periodic.start(time.Millisecond, r.flush)
+	if r.periodic.stop != nil {
+		t.Error("Expected a closed periodic not to start")
+	}
+}
+
+func TestCountRate(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	c, err := NewWithOptions(addr, Options{FlushInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.CountRate("requests", 4, []string{"tagA"})
+	c.CountRate("requests", 6, []string{"tagA"})
+	expected := "requests.rate:200.000000|g|#tagA"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+	expected = "requests.rate:0.000000|g|#tagA"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestRecordRate(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	r.client.rateWindow = 10 * time.Second
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	r.client.now = func() time.Time { return now }
+
+	for i := 0; i < 20; i++ {
+		r.RecordRate("requests.per_second", []string{"tagA"})
+	}
+	r.flush()
+	now = now.Add(5 * time.Second)
+	for i := 0; i < 10; i++ {
+		r.RecordRate("requests.per_second", []string{"tagA"})
+	}
+	r.flush()
+	// The first events have left the window.
+	now = now.Add(5 * time.Second)
+	r.flush()
+	now = now.Add(time.Hour)
+	r.flush()
+
+	expected := []string{
+		"requests.per_second:2.000000|g|#tagA",
+		"requests.per_second:3.000000|g|#tagA",
+		"requests.per_second:1.000000|g|#tagA",
+		"requests.per_second:0.000000|g|#tagA",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestRegisterUtilization(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	c, err := NewWithOptions(addr, Options{FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ch := make(chan int, 4)
+	ch <- 1
+	c.RegisterUtilization("queue.utilization", nil, func() (int, int) { return len(ch), cap(ch) })
+	expected := "queue.utilization:25.000000|g"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+
+	c.RegisterUtilization("queue.utilization", nil, func() (int, int) { return 0, 0 })
+	for {
+		// A flush may already be under way with the previous function.
+		if message := serverRead(t, server); message == "queue.utilization:0.000000|g" {
+			break
+		} else if message != expected {
+			t.Fatalf("Expected: queue.utilization:0.000000|g. Actual: %s", message)
+		}
+	}
+}
+
+func TestUnregisterGauge(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	r.RegisterGauge("worker.busy", []string{"worker:1"}, func() float64 { return 1 })
+	r.GaugeOnChange("worker.version", 3, nil)
+
+	if err := r.UnregisterGauge("worker.busy", []string{"worker:1"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.gauges.gauges); n != 0 {
+		t.Errorf("Expected the gauge to be unregistered, got %d", n)
+	}
+	r.UnregisterGauge("worker.version", nil, false)
+	r.GaugeOnChange("worker.version", 3, nil)
+
+	expected := []string{
+		"worker.version:3.000000|g",
+		"worker.busy:0.000000|g|#worker:1",
+		"worker.version:3.000000|g",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestUnregisterRates(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	r.CountRate("requests", 1, nil)
+	r.RecordRate("requests.per_second", nil)
+
+	r.UnregisterGauge("requests.rate", nil, false)
+	r.UnregisterGauge("requests.per_second", nil, false)
+	if names := r.RegisteredGauges(); len(names) != 0 {
+		t.Errorf("Expected the rates to be unregistered, got %q", names)
+	}
+	if n, m := len(r.gauges.rates), len(r.gauges.windows); n != 0 || m != 0 {
+		t.Errorf("Expected the rate state to be removed, got %d counters and %d windows", n, m)
+	}
+
+	r.CountRate("requests", 1, nil)
+	r.RecordRate("requests.per_second", nil)
+	expected := []string{"requests.per_second", "requests.rate"}
+	if names := r.RegisteredGauges(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, names)
+	}
+}
+
+func TestRegisterGaugeWithTTL(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	r.client.now = func() time.Time { return now }
+
+	value := 2.0
+	r.RegisterGaugeWithTTL("tenant.users", []string{"tenant:a"}, func() float64 { return value }, time.Minute)
+	r.flush()
+	now = now.Add(50 * time.Second)
+	value = 3
+	r.flush()
+	now = now.Add(50 * time.Second)
+	r.flush()
+	now = now.Add(50 * time.Second)
+	// The value has not changed for 100s, past the TTL.
+	r.flush()
+	r.flush()
+
+	expected := []string{
+		"tenant.users:2.000000|g|#tenant:a",
+		"tenant.users:3.000000|g|#tenant:a",
+		"tenant.users:3.000000|g|#tenant:a",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+	if names := r.RegisteredGauges(); len(names) != 0 {
+		t.Errorf("Expected the gauge to be unregistered, got %q", names)
+	}
+}
+
+func TestRegisteredGauges(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	if names := r.RegisteredGauges(); len(names) != 0 {
+		t.Errorf("Expected no gauges, got %q", names)
+	}
+	r.RegisterGauge("worker.busy", []string{"worker:1"}, func() float64 { return 1 })
+	r.RegisterGauge("worker.busy", []string{"worker:2"}, func() float64 { return 1 })
+	r.RegisterUtilization("pool.utilization", nil, func() (int, int) { return 1, 2 })
+	r.CountRate("requests", 1, nil)
+
+	expected := []string{"pool.utilization", "requests.rate", "worker.busy"}
+	if names := r.RegisteredGauges(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, names)
+	}
+	r.UnregisterGauge("pool.utilization", nil, false)
+	if names := r.RegisteredGauges(); !reflect.DeepEqual(names, expected[1:]) {
+		t.Errorf("Expected: %q. Actual: %q", expected[1:], names)
+	}
+}
+
+func TestRegisterGaugeDerivedClients(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	r.WithNamespace("a").RegisterGauge("x", nil, func() float64 { return 1 })
+	r.WithNamespace("b").RegisterGauge("x", nil, func() float64 { return 2 })
+	r.WithTags("env:a").CountRate("requests", 1, nil)
+	r.WithTags("env:b").CountRate("requests", 1, nil)
+
+	expected := []string{"a.x", "b.x", "requests.rate"}
+	if names := r.RegisteredGauges(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, names)
+	}
+	if n := len(r.gauges.gauges); n != 4 {
+		t.Errorf("Expected 4 registered gauges, got %d", n)
+	}
+
+	r.WithNamespace("a").UnregisterGauge("x", nil, false)
+	r.flush()
+	sent := r.Sent()
+	sort.Strings(sent)
+	expected = []string{"b.x:2.000000|g", "requests.rate:0.100000|g|#env:a", "requests.rate:0.100000|g|#env:b"}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
+
+func TestRegisterTTLGauge(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	now := time.Date(2014, time.September, 18, 22, 56, 0, 0, time.UTC)
+	r.client.now = func() time.Time { return now }
+
+	g := r.RegisterTTLGauge("uploads.active", nil, time.Minute)
+	r.flush()
+	g.Set(3)
+	now = now.Add(time.Minute)
+	r.flush()
+	now = now.Add(time.Second)
+	r.flush()
+	g.Set(2)
+	r.flush()
+
+	expected := []string{
+		"uploads.active:0.000000|g",
+		"uploads.active:3.000000|g",
+		"uploads.active:0.000000|g",
+		"uploads.active:2.000000|g",
+	}
+	if sent := r.Sent(); !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected: %q. Actual: %q", expected, sent)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NewPrometheusHandler returns an http.Handler rendering the state kept by
+// c in the Prometheus text exposition format, so that a Prometheus scraper
+// can read the values c sends to Datadog:
+//
+//   - each Counter, as an untyped metric holding the amount accumulated
+//     since it was last flushed,
+//   - each gauge registered with RegisterGauge, read from its function,
+//   - the last value sent by GaugeOnChange for every name and tags.
+//
+// The gauges of CountRate are not exported, as reading them would restart
+// their count. Names include the namespace, with characters Prometheus does
+// not allow replaced by underscores, and tags of the form key:value become
+// labels, other tags becoming key="true". It returns an error if c was not
+// created by this package.
+func NewPrometheusHandler(c Client) (http.Handler, error) {
+	var cc *client
+	switch c := c.(type) {
+	case *client:
+		cc = c
+	case *Recorder:
+		cc = c.client
+	default:
+		return nil, errors.New("Client does not keep state to export")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(cc.prometheusExposition())
+	}), nil
+}
+
+// promSample is one line of the Prometheus exposition.
+type promSample struct {
+	name, kind string
+	tags       []string
+	value      float64
+}
+
+func (c *client) prometheusExposition() []byte {
+	var samples []promSample
+
+	c.counters.mu.Lock()
+	for key, counter := range c.counters.counters {
+		// The key already holds the namespace and global tags.
+		name, tags := splitMetricKey(key)
+		samples = append(samples, promSample{name, "untyped", tags, float64(counter.Value())})
+	}
+	c.counters.mu.Unlock()
+
+	c.gauges.mu.Lock()
+	gauges := make([]*registeredGauge, 0, len(c.gauges.gauges))
+	for _, g := range c.gauges.gauges {
+		if !g.rate {
+			gauges = append(gauges, g)
+		}
+	}
+	c.gauges.mu.Unlock()
+	for _, g := range gauges {
+		samples = append(samples, promSample{g.c.metricName(g.name), "gauge", g.c.mergeTags(g.tags), g.f()})
+	}
+
+	c.lastGauges.mu.Lock()
+	for key, last := range c.lastGauges.values {
+		// The key already holds the namespace and global tags.
+		name, tags := splitMetricKey(key)
+		samples = append(samples, promSample{name, "gauge", tags, last.value})
+	}
+	c.lastGauges.mu.Unlock()
+
+	for i := range samples {
+		samples[i].name = promNameEscaper.ReplaceAllString(samples[i].name, "_")
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].name < samples[j].name })
+
+	var b bytes.Buffer
+	for i, s := range samples {
+		if i == 0 || samples[i-1].name != s.name {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", s.name, s.kind)
+		}
+		b.WriteString(s.name)
+		if labels := promLabels(s.tags); labels != "" {
+			fmt.Fprintf(&b, "{%s}", labels)
+		}
+		fmt.Fprintf(&b, " %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	return b.Bytes()
+}
+
+var (
+	promNameEscaper  = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	promLabelEscaper = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	promValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// promLabels formats tags as Prometheus labels, keeping the first of any
+// tags with the same key.
+func promLabels(tags []string) string {
+	seen := make(map[string]bool, len(tags))
+	labels := make([]string, 0, len(tags))
+	for _, t := range tags {
+		key, value, ok := strings.Cut(t, ":")
+		if !ok {
+			value = "true"
+		}
+		key = promLabelEscaper.ReplaceAllString(key, "_")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, key, promValueEscaper.Replace(value)))
+	}
+	return strings.Join(labels, ",")
+}
+
+// splitMetricKey reverses metricKey.
+func splitMetricKey(key string) (string, []string) {
+	name, tags, _ := strings.Cut(key, "|")
+	if tags == "" {
+		return name, nil
+	}
+	return name, strings.Split(tags, ",")
+}
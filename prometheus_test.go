@@ -0,0 +1,69 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	r := NewRecorder(10)
+	r.SetNamespace("app.")
+	r.SetTags([]string{"env:prod"})
+	r.Counter("jobs.done", []string{"queue:high"}).Add(3)
+	r.RegisterGauge("workers", nil, func() float64 { return 4 })
+	r.CountRate("requests", 1, nil)
+	r.GaugeOnChange("config.version", 2.5, []string{"flag"})
+	defer r.Close()
+
+	handler, err := NewPrometheusHandler(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+
+	expected := `# TYPE app_config_version gauge
+app_config_version{env="prod",flag="true"} 2.5
+# TYPE app_jobs_done untyped
+app_jobs_done{env="prod",queue="high"} 3
+# TYPE app_workers gauge
+app_workers{env="prod"} 4
+`
+	if string(body) != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, body)
+	}
+	if r.Counter("jobs.done", []string{"queue:high"}).Value() != 3 {
+		t.Error("Expected scraping to leave the counter unchanged")
+	}
+
+	if _, err := NewPrometheusHandler(nil); err == nil {
+		t.Error("Expected an error for a Client not created by this package")
+	}
+}
+
+func TestPrometheusHandlerNamespaceSeparator(t *testing.T) {
+	r := NewRecorder(10)
+	defer r.Close()
+	r.SetNamespace("flubber")
+	r.GaugeOnChange("cfg", 1, nil)
+
+	handler, err := NewPrometheusHandler(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+
+	expected := "# TYPE flubber_cfg gauge\nflubber_cfg 1\n"
+	if string(body) != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, body)
+	}
+	if sent := r.Sent(); len(sent) != 1 || sent[0] != "flubber.cfg:1.000000|g" {
+		t.Errorf("Expected the gauge sent as flubber.cfg, got %q", sent)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queue hands payloads to a single worker goroutine that writes them to w.
+type queue struct {
+	mu         sync.RWMutex
+	closed     bool
+	ch         chan queued
+	dropOnFull bool
+	dropped    uint64
+	done       chan struct{}
+	// Whether the time payloads spend queued is measured, and the total time
+	// and count measured since the last call to latency
+	trackLatency bool
+	latencyNanos int64
+	latencyCount int64
+	// Last write error, only accessed by the worker until done is closed
+	err error
+}
+
+// queued is a payload to write, or a request to report on the writes since
+// the previous flush when flushed is set.
+type queued struct {
+	p       []byte
+	flushed chan error
+	// When p was queued, if latency is tracked
+	at time.Time
+}
+
+func newQueue(w io.Writer, size int, dropOnFull, trackLatency bool) *queue {
+	q := &queue{
+		ch:           make(chan queued, size),
+		dropOnFull:   dropOnFull,
+		done:         make(chan struct{}),
+		trackLatency: trackLatency,
+	}
+	go q.run(w)
+	return q
+}
+
+func (q *queue) run(w io.Writer) {
+	defer close(q.done)
+	var flushErr error
+	for item := range q.ch {
+		if item.flushed != nil {
+			item.flushed <- flushErr
+			flushErr = nil
+			continue
+		}
+		// The caller has already returned, so keep the error for close.
+		if _, err := w.Write(item.p); err != nil {
+			q.err = err
+			flushErr = err
+		}
+		if q.trackLatency {
+			atomic.AddInt64(&q.latencyNanos, int64(time.Since(item.at)))
+			atomic.AddInt64(&q.latencyCount, 1)
+		}
+	}
+}
+
+// enqueue adds p to the queue. When the queue is full it either blocks or, if
+// dropOnFull is set, discards p and counts it as dropped.
+func (q *queue) enqueue(p []byte) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClientClosed
+	}
+	item := queued{p: p}
+	if q.trackLatency {
+		item.at = time.Now()
+	}
+	if !q.dropOnFull {
+		q.ch <- item
+		return nil
+	}
+	select {
+	case q.ch <- item:
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+	return nil
+}
+
+// latency returns the mean time the payloads written since the last call
+// spent queued, including the time to write them, or zero if none were.
+func (q *queue) latency() time.Duration {
+	count := atomic.SwapInt64(&q.latencyCount, 0)
+	nanos := atomic.SwapInt64(&q.latencyNanos, 0)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(nanos / count)
+}
+
+// flush waits until the payloads queued before it have been written and
+// returns the last error writing any payload since the previous flush.
+func (q *queue) flush() error {
+	flushed := make(chan error, 1)
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return ErrClientClosed
+	}
+	q.ch <- queued{flushed: flushed}
+	q.mu.RUnlock()
+	return <-flushed
+}
+
+// close stops accepting payloads and waits for the queued ones to be written.
+// It returns the last error the worker got writing a payload.
+func (q *queue) close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+	return q.err
+}
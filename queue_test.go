@@ -0,0 +1,287 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingWriter signals each Write on started and then waits for release.
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	return len(p), nil
+}
+
+func TestQueueDropOnFull(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}, 4), release: make(chan struct{})}
+	q := newQueue(w, 2, true, false)
+
+	// The worker takes the first payload and blocks writing it.
+	q.enqueue([]byte("a"))
+	<-w.started
+	// Fill the queue, then overflow it.
+	for _, p := range []string{"b", "c", "d"} {
+		if err := q.enqueue([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dropped := atomic.LoadUint64(&q.dropped); dropped != 1 {
+		t.Errorf("Expected 1 dropped payload, got %d", dropped)
+	}
+
+	close(w.release)
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.enqueue([]byte("e")); err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v", err)
+	}
+}
+
+func TestAsyncClient(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewWithOptions(addr, Options{QueueSize: 8, DropOnFull: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"test.count:1|c", "test.count:2|c", "test.count:3|c"}
+	for i := range expected {
+		if err := client.Count("test.count", int64(i+1), nil, 1.0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range expected {
+		if message := serverRead(t, server); message != e {
+			t.Errorf("Expected: %s. Actual: %s", e, message)
+		}
+	}
+	if dropped := client.Dropped(); dropped != 0 {
+		t.Errorf("Expected no dropped metrics, got %d", dropped)
+	}
+}
+
+// failingConn fails every Write and Close with its own error.
+type failingConn struct {
+	writeErr, closeErr error
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	return 0, c.writeErr
+}
+
+func (c *failingConn) Close() error {
+	return c.closeErr
+}
+
+func TestCloseJoinsErrors(t *testing.T) {
+	conn := &failingConn{writeErr: errors.New("write failed"), closeErr: errors.New("close failed")}
+	c, err := newConnClient(conn, Options{QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Count("test.count", 1, nil, 1.0); err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Close()
+	if !errors.Is(err, conn.writeErr) || !errors.Is(err, conn.closeErr) {
+		t.Errorf("Expected write and close errors, got %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Expected second Close to do nothing, got %v", err)
+	}
+	if err := c.WithTags("tagA").Close(); err != nil {
+		t.Errorf("Expected Close of a derived client to do nothing, got %v", err)
+	}
+}
+
+func TestAsyncOrder(t *testing.T) {
+	r := &ring{buf: make([]string, 500)}
+	c, err := newConnClient(r, Options{QueueSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := c.Count("test.count", int64(i), nil, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sent := r.contents()
+	if len(sent) != 500 {
+		t.Fatalf("Expected 500 payloads, got %d", len(sent))
+	}
+	for i, message := range sent {
+		if expected := fmt.Sprintf("test.count:%d|c", i); message != expected {
+			t.Fatalf("Expected: %s. Actual: %s", expected, message)
+		}
+	}
+}
+
+func TestNewAsync(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client, err := NewAsync(addr, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Count("test.count", 1, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := "test.count:1|c"
+	if message := serverRead(t, server); message != expected {
+		t.Errorf("Expected: %s. Actual: %s", expected, message)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{QueueSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		c.Count("test.count", int64(i), nil, 1)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sent := r.contents(); len(sent) != 3 {
+		t.Errorf("Expected 3 payloads written by Flush, got %q", sent)
+	}
+
+	conn := &failingConn{writeErr: errors.New("write failed")}
+	failing, err := newConnClient(conn, Options{QueueSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing.Count("test.count", 1, nil, 1)
+	if err := failing.Flush(); err != conn.writeErr {
+		t.Errorf("Expected the write error, got %v", err)
+	}
+	if err := failing.Flush(); err != nil {
+		t.Errorf("Expected no error without new writes, got %v", err)
+	}
+	failing.Close()
+	if err := failing.Flush(); err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v", err)
+	}
+}
+
+// holdConn holds writes of payloads containing hold until release is closed.
+type holdConn struct {
+	ring
+	hold    string
+	release chan struct{}
+}
+
+func (c *holdConn) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), c.hold) {
+		<-c.release
+	}
+	return c.ring.Write(p)
+}
+
+func TestSendNow(t *testing.T) {
+	conn := &holdConn{ring: ring{buf: make([]string, 10)}, hold: "test.bulk", release: make(chan struct{})}
+	c, err := newConnClient(conn, Options{QueueSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The queue is stuck writing the bulk metric, which the others overtake.
+	c.Count("test.bulk", 1, nil, 1)
+	if err := c.GaugeNow("test.gauge", 1.5, []string{"tagA"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CountNow("test.count", 2, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"test.gauge:1.500000|g|#tagA", "test.count:2|c"}
+	if sent := conn.contents(); fmt.Sprint(sent) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q before the queued metric, got %q", expected, sent)
+	}
+
+	close(conn.release)
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if sent := conn.contents(); len(sent) != 3 || sent[2] != "test.bulk:1|c" {
+		t.Errorf("Expected the queued metric last, got %q", sent)
+	}
+}
+
+func TestQueueLatency(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}, 4), release: make(chan struct{})}
+	q := newQueue(w, 2, false, true)
+	q.enqueue([]byte("a"))
+	<-w.started
+	time.Sleep(20 * time.Millisecond)
+	close(w.release)
+	q.close()
+	if latency := q.latency(); latency < 20*time.Millisecond {
+		t.Errorf("Expected a latency of at least 20ms, got %v", latency)
+	}
+	if latency := q.latency(); latency != 0 {
+		t.Errorf("Expected the latency to restart, got %v", latency)
+	}
+
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	c, err := NewWithOptions(addr, Options{QueueSize: 4, QueueLatency: true, FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetNamespace("flubber.")
+	if message := serverRead(t, server); !strings.HasPrefix(message, "datadog.dogstatsd.client.queue_latency:") {
+		t.Errorf("Expected the queue latency gauge, got %s", message)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}, 4), release: make(chan struct{})}
+	c, err := newConnClient(&failingConn{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.QueueDepth() != 0 || c.QueueCapacity() != 0 {
+		t.Errorf("Expected no queue, got %d of %d", c.QueueDepth(), c.QueueCapacity())
+	}
+
+	c.queue = newQueue(w, 4, false, false)
+	c.Count("test.count", 1, nil, 1)
+	<-w.started
+	c.Count("test.count", 2, nil, 1)
+	c.Count("test.count", 3, nil, 1)
+	if c.QueueDepth() != 2 || c.QueueCapacity() != 4 {
+		t.Errorf("Expected 2 of 4 queued, got %d of %d", c.QueueDepth(), c.QueueCapacity())
+	}
+	close(w.release)
+	c.Close()
+}
@@ -0,0 +1,9 @@
+// Copyright 2013 Ooyala, Inc.
+
+//go:build race
+
+package dogstatsd
+
+// raceEnabled is set when testing with the race detector, which allocates
+// on its own.
+const raceEnabled = true
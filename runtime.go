@@ -0,0 +1,120 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// runtimeMetric is a gauge that StartRuntimeMetrics can report.
+type runtimeMetric struct {
+	field string
+	name  string
+	value func(*runtime.MemStats) float64
+}
+
+// runtimeMetrics are the gauges StartRuntimeMetrics can report, keyed by
+// field in the order they are sent.
+var runtimeMetrics = []runtimeMetric{
+	{"NumGoroutine", "runtime.go.goroutines", nil},
+	{"Alloc", "runtime.go.alloc", func(m *runtime.MemStats) float64 { return float64(m.Alloc) }},
+	{"TotalAlloc", "runtime.go.total_alloc", func(m *runtime.MemStats) float64 { return float64(m.TotalAlloc) }},
+	{"Sys", "runtime.go.sys", func(m *runtime.MemStats) float64 { return float64(m.Sys) }},
+	{"Mallocs", "runtime.go.mallocs", func(m *runtime.MemStats) float64 { return float64(m.Mallocs) }},
+	{"Frees", "runtime.go.frees", func(m *runtime.MemStats) float64 { return float64(m.Frees) }},
+	{"HeapAlloc", "runtime.go.heap_alloc", func(m *runtime.MemStats) float64 { return float64(m.HeapAlloc) }},
+	{"HeapSys", "runtime.go.heap_sys", func(m *runtime.MemStats) float64 { return float64(m.HeapSys) }},
+	{"HeapIdle", "runtime.go.heap_idle", func(m *runtime.MemStats) float64 { return float64(m.HeapIdle) }},
+	{"HeapInuse", "runtime.go.heap_inuse", func(m *runtime.MemStats) float64 { return float64(m.HeapInuse) }},
+	{"HeapReleased", "runtime.go.heap_released", func(m *runtime.MemStats) float64 { return float64(m.HeapReleased) }},
+	{"HeapObjects", "runtime.go.heap_objects", func(m *runtime.MemStats) float64 { return float64(m.HeapObjects) }},
+	{"StackInuse", "runtime.go.stack_inuse", func(m *runtime.MemStats) float64 { return float64(m.StackInuse) }},
+	{"StackSys", "runtime.go.stack_sys", func(m *runtime.MemStats) float64 { return float64(m.StackSys) }},
+	{"NextGC", "runtime.go.gc.next", func(m *runtime.MemStats) float64 { return float64(m.NextGC) }},
+	{"NumGC", "runtime.go.gc.count", func(m *runtime.MemStats) float64 { return float64(m.NumGC) }},
+	{"PauseNs", "runtime.go.gc.pause", func(m *runtime.MemStats) float64 { return float64(m.PauseNs[(m.NumGC+255)%256]) }},
+	{"PauseTotalNs", "runtime.go.gc.pause_total", func(m *runtime.MemStats) float64 { return float64(m.PauseTotalNs) }},
+	{"GCCPUFraction", "runtime.go.gc.cpu_fraction", func(m *runtime.MemStats) float64 { return m.GCCPUFraction }},
+}
+
+// defaultRuntimeFields are the fields reported when none are given.
+var defaultRuntimeFields = []string{"NumGoroutine", "HeapAlloc", "HeapSys", "HeapObjects", "NumGC", "PauseNs"}
+
+// StartRuntimeMetrics reports Go runtime statistics as gauges every interval
+// until the client is closed. Calls after the first one have no effect.
+// fields selects the statistics reported, by the name of their
+// runtime.MemStats field or NumGoroutine for runtime.NumGoroutine(), and
+// defaults to those marked with * below. An interval that is not positive or
+// an unknown field returns an error without starting anything. The gauges
+// sent, prefixed with the client namespace, are:
+//
+//	NumGoroutine  *  runtime.go.goroutines       number of goroutines
+//	Alloc            runtime.go.alloc            bytes of allocated heap objects
+//	TotalAlloc       runtime.go.total_alloc      cumulative bytes allocated
+//	Sys              runtime.go.sys              bytes of memory obtained from the OS
+//	Mallocs          runtime.go.mallocs          cumulative heap objects allocated
+//	Frees            runtime.go.frees            cumulative heap objects freed
+//	HeapAlloc     *  runtime.go.heap_alloc       bytes of allocated heap objects
+//	HeapSys       *  runtime.go.heap_sys         bytes of heap memory obtained from the OS
+//	HeapIdle         runtime.go.heap_idle        bytes in idle heap spans
+//	HeapInuse        runtime.go.heap_inuse       bytes in in-use heap spans
+//	HeapReleased     runtime.go.heap_released    bytes of heap memory returned to the OS
+//	HeapObjects   *  runtime.go.heap_objects     number of allocated heap objects
+//	StackInuse       runtime.go.stack_inuse      bytes in stack spans
+//	StackSys         runtime.go.stack_sys        bytes of stack memory obtained from the OS
+//	NextGC           runtime.go.gc.next          heap size targeted by the next GC cycle
+//	NumGC         *  runtime.go.gc.count         number of completed GC cycles
+//	PauseNs       *  runtime.go.gc.pause         duration of the most recent GC pause in nanoseconds
+//	PauseTotalNs     runtime.go.gc.pause_total   cumulative GC pause duration in nanoseconds
+//	GCCPUFraction    runtime.go.gc.cpu_fraction  fraction of CPU time used by the GC
+//
+// Every field but NumGoroutine requires runtime.ReadMemStats, which briefly
+// stops the world, so it is only called when one of them is selected.
+func (c *client) StartRuntimeMetrics(interval time.Duration, fields []string) error {
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	if interval <= 0 {
+		return fmt.Errorf("Runtime metrics interval %v is not positive", interval)
+	}
+	if len(fields) == 0 {
+		fields = defaultRuntimeFields
+	}
+	selected := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		selected[field] = true
+	}
+	var metrics []runtimeMetric
+	readMemStats := false
+	for _, m := range runtimeMetrics {
+		if selected[m.field] {
+			metrics = append(metrics, m)
+			readMemStats = readMemStats || m.value != nil
+			delete(selected, m.field)
+		}
+	}
+	for _, field := range fields {
+		if selected[field] {
+			return fmt.Errorf("Runtime metric field '%s' is unknown", field)
+		}
+	}
+	c.runtimeMetrics.start(interval, func() { c.sendRuntimeMetrics(metrics, readMemStats) })
+	return nil
+}
+
+func (c *client) sendRuntimeMetrics(metrics []runtimeMetric, readMemStats bool) {
+	var m runtime.MemStats
+	if readMemStats {
+		runtime.ReadMemStats(&m)
+	}
+	for _, metric := range metrics {
+		value := float64(runtime.NumGoroutine())
+		if metric.value != nil {
+			value = metric.value(&m)
+		}
+		// Nobody is waiting on a periodic report to return errors to.
+		c.Gauge(metric.name, value, nil, 1)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartRuntimeMetrics(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	client.SetNamespace("flubber.")
+
+	if err := client.StartRuntimeMetrics(10*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"runtime.go.goroutines",
+		"runtime.go.heap_alloc",
+		"runtime.go.heap_sys",
+		"runtime.go.heap_objects",
+		"runtime.go.gc.count",
+		"runtime.go.gc.pause",
+	} {
+		message := serverRead(t, server)
+		if !strings.HasPrefix(message, "flubber."+name+":") || !strings.HasSuffix(message, "|g") {
+			t.Errorf("Expected gauge %s. Actual: %s", name, message)
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartRuntimeMetricsFields(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	client := newClient(t, addr)
+	defer client.Close()
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := client.StartRuntimeMetrics(interval, nil); err == nil {
+			t.Errorf("Expected an error for the interval %v", interval)
+		}
+	}
+	if err := client.StartRuntimeMetrics(10*time.Millisecond, []string{"HeapAlloc", "Heap"}); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	// The order of fields doesn't matter.
+	if err := client.StartRuntimeMetrics(10*time.Millisecond, []string{"NumGC", "Sys"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		name := []string{"runtime.go.sys", "runtime.go.gc.count"}[i%2]
+		if message := serverRead(t, server); !strings.HasPrefix(message, name+":") {
+			t.Errorf("Expected gauge %s. Actual: %s", name, message)
+		}
+	}
+}
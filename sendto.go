@@ -0,0 +1,92 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// sendToConns caches the connections opened by SendTo, keyed by address.
+type sendToConns struct {
+	mu    sync.Mutex
+	conns map[string]net.Conn
+	// Set by close, after which no connection is dialed
+	closed bool
+}
+
+// conn returns the cached connection to addr, dialing it if needed. It
+// returns ErrClientClosed once close has been called, so that a SendTo
+// racing with Close can't leave a connection open.
+func (s *sendToConns) conn(addr string) (net.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClientClosed
+	}
+	if conn, ok := s.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.conns[addr] = conn
+	return conn, nil
+}
+
+// drop closes and forgets the connection to addr if it is still conn.
+func (s *sendToConns) drop(addr string, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[addr] == conn {
+		delete(s.conns, addr)
+		conn.Close()
+	}
+}
+
+// close closes every cached connection and stops new ones being dialed.
+func (s *sendToConns) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var errs []error
+	for addr, conn := range s.conns {
+		errs = append(errs, conn.Close())
+		delete(s.conns, addr)
+	}
+	return errors.Join(errs...)
+}
+
+// SendTo sends a single metric, formatted as by Submit with the namespace and
+// global tags of c, to the agent at addr over UDP instead of to the agent of
+// c, e.g. to copy a metric to a local agent while debugging. It is written
+// at once, bypassing the queue of an asynchronous client.
+//
+// The connection to addr is dialed on first use and cached for the
+// following calls, so routing metrics through SendTo costs no more than a
+// write each. Cached connections are shared with derived clients and stay
+// open until c is closed; a connection whose write fails is closed and
+// dialed again on the next call.
+func (c *client) SendTo(addr string, mtype MetricType, name string, value float64, tags []string, rate float64) error {
+	if c.off() {
+		return nil
+	}
+	if c.closed.Load() {
+		return ErrClientClosed
+	}
+	data, err := c.format(mtype, name, c.formatFloat(value), tags, rate)
+	if data == nil {
+		return err
+	}
+	conn, err := c.sendTo.conn(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(data); err != nil {
+		c.sendTo.drop(addr, conn)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"testing"
+)
+
+func TestSendTo(t *testing.T) {
+	addr := "localhost:1201"
+	server := newServer(t, addr)
+	defer server.Close()
+	r := NewRecorder(10)
+	r.SetNamespace("flubber.")
+
+	for i := 1; i <= 2; i++ {
+		if err := r.SendTo(addr, Gauge, "test.gauge", float64(i), []string{"tagA"}, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"flubber.test.gauge:1.000000|g|#tagA", "flubber.test.gauge:2.000000|g|#tagA"} {
+		if message := serverRead(t, server); message != expected {
+			t.Errorf("Expected: %s. Actual: %s", expected, message)
+		}
+	}
+	if sent := r.Sent(); len(sent) != 0 {
+		t.Errorf("Expected nothing sent to the client's own agent, got %q", sent)
+	}
+	if n := len(r.sendTo.conns); n != 1 {
+		t.Errorf("Expected 1 cached connection, got %d", n)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.sendTo.conns); n != 0 {
+		t.Errorf("Expected the cached connections to be closed, got %d", n)
+	}
+	if err := r.SendTo(addr, Gauge, "test.gauge", 3, nil, 1); err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v", err)
+	}
+}
+
+func TestSendToRacingClose(t *testing.T) {
+	r := NewRecorder(1)
+	defer r.Close()
+	// A SendTo that passed the closed check just before Close closed the
+	// cached connections must not dial a new one.
+	if err := r.sendTo.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SendTo("localhost:1201", Gauge, "test.gauge", 1, nil, 1); err != ErrClientClosed {
+		t.Errorf("Expected ErrClientClosed, got %v", err)
+	}
+	if n := len(r.sendTo.conns); n != 0 {
+		t.Errorf("Expected no connection to be dialed, got %d", n)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxExpandedNames bounds the number of expanded names cached by a
+// nameTemplates, in case names are built dynamically.
+const maxExpandedNames = 4096
+
+// nameTemplates expands the {placeholder}s of Options.NamePlaceholders in
+// metric names. Each distinct name is expanded once and cached.
+type nameTemplates struct {
+	values   map[string]string
+	mu       sync.RWMutex
+	expanded map[string]string
+}
+
+// newNameTemplates returns the nameTemplates for values, or nil if there are
+// none. Values may not contain the characters of the DogStatsD format.
+func newNameTemplates(values map[string]string) (*nameTemplates, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	t := &nameTemplates{values: make(map[string]string, len(values)), expanded: make(map[string]string)}
+	for k, v := range values {
+		if strings.ContainsAny(v, reservedSeparatorChars+"\n") {
+			return nil, fmt.Errorf("Placeholder '%s' value %q contains a reserved DogStatsD character", k, v)
+		}
+		t.values[k] = v
+	}
+	return t, nil
+}
+
+// expand returns name with its known placeholders replaced.
+func (t *nameTemplates) expand(name string) string {
+	if t == nil || strings.IndexByte(name, '{') < 0 {
+		return name
+	}
+	t.mu.RLock()
+	expanded, ok := t.expanded[name]
+	t.mu.RUnlock()
+	if ok {
+		return expanded
+	}
+
+	var b strings.Builder
+	rest := name
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			break
+		}
+		end += open
+		b.WriteString(rest[:open])
+		if v, ok := t.values[rest[open+1:end]]; ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(rest[open : end+1])
+		}
+		rest = rest[end+1:]
+	}
+	b.WriteString(rest)
+	expanded = b.String()
+
+	t.mu.Lock()
+	if len(t.expanded) < maxExpandedNames {
+		t.expanded[name] = expanded
+	}
+	t.mu.Unlock()
+	return expanded
+}
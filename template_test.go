@@ -0,0 +1,40 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"testing"
+)
+
+func TestNamePlaceholders(t *testing.T) {
+	r := &ring{buf: make([]string, 10)}
+	c, err := newConnClient(r, Options{NamePlaceholders: map[string]string{"region": "eu", "tier": "web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetNamespace("{tier}.")
+	c.Count("service.{region}.requests", 1, nil, 1)
+	c.Count("service.{region}.requests", 2, nil, 1)
+	c.Count("service.{zone}.{region", 3, nil, 1)
+	c.WithNamespace("{region}").Count("errors", 4, nil, 1)
+
+	expected := []string{
+		"web.service.eu.requests:1|c",
+		"web.service.eu.requests:2|c",
+		"web.service.{zone}.{region:3|c",
+		"web.eu.errors:4|c",
+	}
+	sent := r.contents()
+	if len(sent) != len(expected) {
+		t.Fatalf("Expected %q, got %q", expected, sent)
+	}
+	for i, e := range expected {
+		if sent[i] != e {
+			t.Errorf("Expected: %s. Actual: %s", e, sent[i])
+		}
+	}
+
+	if _, err := newConnClient(r, Options{NamePlaceholders: map[string]string{"region": "eu|us"}}); err == nil {
+		t.Error("Expected an error for a value with a reserved character")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TagRule is a Datadog tag rule broken by a TagViolation.
+type TagRule string
+
+const (
+	// TagEmpty is broken by an empty tag.
+	TagEmpty TagRule = "empty"
+	// TagTooLong is broken by a tag longer than 200 characters, which
+	// Datadog truncates.
+	TagTooLong TagRule = "too_long"
+	// TagStart is broken by a tag that doesn't start with a letter.
+	TagStart TagRule = "start"
+	// TagChars is broken by a tag with characters other than letters,
+	// digits, underscores, minuses, colons, periods and slashes. Datadog
+	// replaces them with underscores, and some of them split the line.
+	TagChars TagRule = "chars"
+	// TagUppercase is broken by a tag with uppercase letters, which Datadog
+	// converts to lowercase.
+	TagUppercase TagRule = "uppercase"
+	// TagTrailingColon is broken by a tag ending with a colon.
+	TagTrailingColon TagRule = "trailing_colon"
+	// TagReservedPrefix is broken by a tag starting with "dd.", the prefix
+	// of the tags Datadog and this client use internally.
+	TagReservedPrefix TagRule = "reserved_prefix"
+)
+
+// maxTagChars is the longest tag Datadog keeps whole.
+const maxTagChars = 200
+
+// TagViolation describes a tag breaking a Datadog tag rule.
+type TagViolation struct {
+	// Index of the tag in the validated slice
+	Index int
+	Tag   string
+	Rule  TagRule
+}
+
+func (v TagViolation) Error() string {
+	return fmt.Sprintf("Tag '%s' at index %d breaks the %s rule", v.Tag, v.Index, v.Rule)
+}
+
+// ValidateTags checks tags against the rules Datadog applies to tags and
+// returns a violation for each rule each tag breaks, in order, or nil if
+// all tags comply. It sends nothing and is never called when sending, so
+// that it can be used to lint instrumentation, e.g. in a test, at no cost
+// to the send path.
+func ValidateTags(tags []string) []TagViolation {
+	var violations []TagViolation
+	for i, tag := range tags {
+		add := func(rule TagRule) {
+			violations = append(violations, TagViolation{Index: i, Tag: tag, Rule: rule})
+		}
+		if tag == "" {
+			add(TagEmpty)
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagChars {
+			add(TagTooLong)
+		}
+		if first, _ := utf8.DecodeRuneInString(tag); !unicode.IsLetter(first) {
+			add(TagStart)
+		}
+		if strings.IndexFunc(tag, invalidTagRune) > -1 {
+			add(TagChars)
+		}
+		if strings.IndexFunc(tag, unicode.IsUpper) > -1 {
+			add(TagUppercase)
+		}
+		if strings.HasSuffix(tag, ":") {
+			add(TagTrailingColon)
+		}
+		if strings.HasPrefix(tag, "dd.") {
+			add(TagReservedPrefix)
+		}
+	}
+	return violations
+}
+
+// invalidTagRune reports whether r is not allowed in a tag.
+func invalidTagRune(r rune) bool {
+	switch r {
+	case '_', '-', ':', '.', '/':
+		return false
+	}
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
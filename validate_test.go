@@ -0,0 +1,38 @@
+// Copyright 2013 Ooyala, Inc.
+
+package dogstatsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTags(t *testing.T) {
+	if violations := ValidateTags([]string{"env:prod", "région:eu-west/1", "version:1.2_3"}); violations != nil {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+
+	long := "a" + strings.Repeat("é", maxTagChars)
+	tags := []string{"", "1st:a", "env:prod|x", "Env:prod", "env:", "dd.internal:x", long}
+	expected := []TagViolation{
+		{0, "", TagEmpty},
+		{1, "1st:a", TagStart},
+		{2, "env:prod|x", TagChars},
+		{3, "Env:prod", TagUppercase},
+		{4, "env:", TagTrailingColon},
+		{5, "dd.internal:x", TagReservedPrefix},
+		{6, long, TagTooLong},
+	}
+	if violations := ValidateTags(tags); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("Expected: %v. Actual: %v", expected, violations)
+	}
+
+	expected = []TagViolation{{0, "#Tag", TagStart}, {0, "#Tag", TagChars}, {0, "#Tag", TagUppercase}}
+	if violations := ValidateTags([]string{"#Tag"}); !reflect.DeepEqual(violations, expected) {
+		t.Errorf("Expected: %v. Actual: %v", expected, violations)
+	}
+	if msg := expected[0].Error(); msg != "Tag '#Tag' at index 0 breaks the start rule" {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+}